@@ -0,0 +1,105 @@
+package app
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// adminCookiePrefix + roomID holds a signature derived from the room's admin
+// token, so the browser never stores the token itself.
+const adminCookiePrefix = "sf_admin_"
+
+func adminCookieName(roomID string) string { return adminCookiePrefix + roomID }
+
+// signAdmin returns the cookie value proving knowledge of the admin token.
+func signAdmin(roomID, token string) string {
+    mac := hmac.New(sha256.New, []byte(token))
+    mac.Write([]byte("admin:" + roomID))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// adminCredential extracts a token from the Authorization / X-Admin-Token
+// headers or the ?token= query parameter. Failing those, the second result
+// is the admin cookie's value, if any.
+func adminCredential(r *http.Request, roomID string) (token string, cookie string) {
+    if v := r.Header.Get("Authorization"); strings.HasPrefix(v, "Bearer ") {
+        return strings.TrimSpace(strings.TrimPrefix(v, "Bearer ")), ""
+    }
+    if v := strings.TrimSpace(r.Header.Get("X-Admin-Token")); v != "" {
+        return v, ""
+    }
+    if v := strings.TrimSpace(r.URL.Query().Get("token")); v != "" {
+        return v, ""
+    }
+    if c, err := r.Cookie(adminCookieName(roomID)); err == nil {
+        return "", c.Value
+    }
+    return "", ""
+}
+
+// checkAdmin returns 0 when the request is authorised for the room,
+// otherwise the HTTP status to reply with (401 missing, 403 wrong).
+func checkAdmin(r *http.Request, rm *room) int {
+    token, cookie := adminCredential(r, rm.ID)
+    switch {
+    case token != "":
+        if subtle.ConstantTimeCompare([]byte(token), []byte(rm.AdminToken)) == 1 {
+            return 0
+        }
+        return http.StatusForbidden
+    case cookie != "":
+        if hmac.Equal([]byte(cookie), []byte(signAdmin(rm.ID, rm.AdminToken))) {
+            return 0
+        }
+        return http.StatusForbidden
+    default:
+        return http.StatusUnauthorized
+    }
+}
+
+// requireAdmin writes an error response and returns false when the request
+// is not authorised for the room.
+func requireAdmin(w http.ResponseWriter, r *http.Request, rm *room) bool {
+    switch checkAdmin(r, rm) {
+    case 0:
+        return true
+    case http.StatusUnauthorized:
+        w.Header().Set("WWW-Authenticate", `Bearer realm="slideflow"`)
+        http.Error(w, "admin token required", http.StatusUnauthorized)
+    default:
+        http.Error(w, "invalid admin token", http.StatusForbidden)
+    }
+    return false
+}
+
+// setAdminCookie stores the signed admin cookie for the room.
+func (s *Server) setAdminCookie(w http.ResponseWriter, r *http.Request, rm *room) {
+    http.SetCookie(w, &http.Cookie{
+        Name:     adminCookieName(rm.ID),
+        Value:    signAdmin(rm.ID, rm.AdminToken),
+        Path:     "/",
+        HttpOnly: true,
+        Secure:   s.proxies.Scheme(r) == "https",
+        SameSite: http.SameSiteStrictMode,
+    })
+}
+
+// sameOrigin reports whether a browser request was made by one of our own
+// pages, so a cookie it carries wasn't sent on another site's behalf.
+// Requests without Origin don't come from a page and pass.
+func (s *Server) sameOrigin(r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return true
+    }
+    u, err := url.Parse(origin)
+    if err != nil || u.Host == "" {
+        return false
+    }
+    return strings.EqualFold(u.Host, r.Host) || strings.EqualFold(u.Host, s.proxies.Host(r))
+}
@@ -0,0 +1,273 @@
+package app
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "slices"
+    "time"
+
+    "slideflow/internal/event"
+    "slideflow/internal/store"
+    "slideflow/internal/util"
+)
+
+const (
+    // maxRoomBans caps a room's ban list.
+    maxRoomBans = 1000
+    // maxMute bounds a mute; anything longer should be a ban.
+    maxMute = 24 * time.Hour
+
+    // participantCookie carries a random ID the post form hands out, so a
+    // participant can be banned across handle and network changes.
+    participantCookie = "sf_pid"
+)
+
+// Ban kinds: what store.Ban.Value holds.
+var banKinds = []string{"identity", "ip", "participant"}
+
+// poster is who sent a comment, as far as moderation can tell.
+type poster struct {
+    Identity string // ip|handle, see util.Proxies.ClientIdentity
+    IP       string
+    PID      string // participant cookie, if any
+}
+
+func (s *Server) newPoster(r *http.Request, handle string) poster {
+    p := poster{Identity: s.proxies.ClientIdentity(r, handle), IP: s.proxies.ClientIP(r)}
+    if c, err := r.Cookie(participantCookie); err == nil {
+        p.PID = c.Value
+    }
+    return p
+}
+
+// key returns the value a ban of the given kind would match for p.
+func (p poster) key(kind string) string {
+    switch kind {
+    case "identity":
+        return p.Identity
+    case "ip":
+        return p.IP
+    case "participant":
+        return p.PID
+    }
+    return ""
+}
+
+// banMatches reports whether b applies to p at now.
+func banMatches(b store.Ban, p poster, now time.Time) bool {
+    if b.Until != nil && !now.Before(*b.Until) {
+        return false
+    }
+    v := p.key(b.Kind)
+    return v != "" && v == b.Value
+}
+
+// banFor returns the ban or mute blocking p, bans first. Callers hold rm.mu.
+func (rm *room) banFor(p poster, now time.Time) *store.Ban {
+    var mute *store.Ban
+    for i := range rm.Bans {
+        b := &rm.Bans[i]
+        if !banMatches(*b, p, now) {
+            continue
+        }
+        if b.Until == nil {
+            return b
+        }
+        mute = b
+    }
+    return mute
+}
+
+// ensureParticipant gives the browser a participant ID if it has none.
+func (s *Server) ensureParticipant(w http.ResponseWriter, r *http.Request) {
+    if _, err := r.Cookie(participantCookie); err == nil {
+        return
+    }
+    id, err := util.NewToken(16)
+    if err != nil {
+        return
+    }
+    http.SetCookie(w, &http.Cookie{
+        Name:     participantCookie,
+        Value:    id,
+        Path:     "/",
+        MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+        HttpOnly: true,
+        SameSite: http.SameSiteLaxMode,
+        Secure:   s.proxies.Scheme(r) == "https",
+    })
+}
+
+// banReq is the body of POST /rooms/{id}/bans. Either Value or MessageID
+// (a shown or held chat) names the participant.
+type banReq struct {
+    Kind       string `json:"kind"`
+    Value      string `json:"value"`
+    MessageID  string `json:"messageId"`
+    DurationMs int64  `json:"durationMs"`
+    Reason     string `json:"reason"`
+}
+
+// banView is a ban as listed to moderators, with the offender's recent
+// comments.
+type banView struct {
+    store.Ban
+    Messages []*event.Chat `json:"messages"`
+}
+
+// /rooms/{id}/bans (admin)
+//
+//     GET              -> { bans: [{ ...ban, messages }] }
+//     POST { kind, value | messageId, durationMs, reason }
+//                      bans (durationMs 0) or mutes a participant
+//     DELETE /{banId}  lifts a ban or mute
+//
+// kind is "identity" (default; IP and handle), "ip" or "participant" (the
+// post form's cookie).
+func (s *Server) handleBans(w http.ResponseWriter, r *http.Request, rm *room, banID string) {
+    if banID != "" && r.Method != http.MethodDelete {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    var perr *postError
+    now := time.Now()
+    var b store.Ban
+    if r.Method == http.MethodPost {
+        // before locking the room: finding a message's poster may read
+        // the review queue from the store
+        var req banReq
+        if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+            perr = &postError{Status: http.StatusBadRequest, Msg: "invalid json"}
+        } else {
+            b, perr = s.newBan(rm, req, now)
+        }
+    }
+    rm.mu.Lock()
+    switch {
+    case perr != nil:
+    case r.Method == http.MethodGet:
+    case r.Method == http.MethodPost:
+        perr = s.setBans(rm, func(bans []store.Ban) ([]store.Ban, *postError) {
+            return append(activeBans(bans, now), b), nil
+        })
+    case r.Method == http.MethodDelete:
+        perr = s.setBans(rm, func(bans []store.Ban) ([]store.Ban, *postError) {
+            i := slices.IndexFunc(bans, func(b store.Ban) bool { return b.ID == banID })
+            if banID == "" || i < 0 {
+                return nil, &postError{Status: http.StatusNotFound, Msg: "ban not found"}
+            }
+            return activeBans(slices.Delete(bans, i, i+1), now), nil
+        })
+    default:
+        perr = &postError{Status: http.StatusMethodNotAllowed, Msg: "method not allowed"}
+    }
+    views := []banView{}
+    if perr == nil {
+        for _, b := range activeBans(rm.Bans, now) {
+            v := banView{Ban: b, Messages: []*event.Chat{}}
+            for _, m := range rm.recent {
+                if banMatches(b, m.Poster, now) {
+                    v.Messages = append(v.Messages, m.Chat)
+                }
+            }
+            views = append(views, v)
+        }
+    }
+    rm.mu.Unlock()
+
+    if perr != nil {
+        http.Error(w, perr.Msg, perr.Status)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    json.NewEncoder(w).Encode(map[string]any{"ok": true, "bans": views})
+}
+
+// newBan turns a request into a ban, resolving a message ID to whoever
+// posted it. Callers don't hold rm.mu.
+func (s *Server) newBan(rm *room, req banReq, now time.Time) (store.Ban, *postError) {
+    b := store.Ban{Kind: req.Kind, Value: req.Value, Reason: req.Reason, CreatedAt: now}
+    if b.Kind == "" {
+        b.Kind = "identity"
+    }
+    if !slices.Contains(banKinds, b.Kind) {
+        return b, &postError{Status: http.StatusBadRequest, Msg: "unknown kind"}
+    }
+    if req.DurationMs < 0 || time.Duration(req.DurationMs)*time.Millisecond > maxMute {
+        return b, &postError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("durationMs must be 0-%d", maxMute.Milliseconds())}
+    }
+    if req.DurationMs > 0 {
+        until := now.Add(time.Duration(req.DurationMs) * time.Millisecond)
+        b.Until = &until
+    }
+    if req.MessageID != "" {
+        from, chat, ok := s.findPoster(rm, req.MessageID)
+        if !ok {
+            return b, &postError{Status: http.StatusNotFound, Msg: "message not found"}
+        }
+        b.Value = from.key(b.Kind)
+        if b.Value == "" {
+            return b, &postError{Status: http.StatusBadRequest, Msg: "message has no " + b.Kind}
+        }
+        b.Label = chat.Handle
+        if b.Label == "" {
+            b.Label = chat.Text
+        }
+    }
+    if b.Value == "" {
+        return b, &postError{Status: http.StatusBadRequest, Msg: "value or messageId required"}
+    }
+    b.ID = util.NewRoomID(8)
+    return b, nil
+}
+
+// findPoster looks a chat up among the room's recent and held comments.
+// Callers don't hold rm.mu: the held comments are in the store.
+func (s *Server) findPoster(rm *room, msgID string) (poster, *event.Chat, bool) {
+    rm.mu.Lock()
+    i := slices.IndexFunc(rm.recent, func(m recentMsg) bool { return m.Chat.ID == msgID })
+    var m recentMsg
+    if i >= 0 {
+        m = rm.recent[i]
+    }
+    rm.mu.Unlock()
+    if i >= 0 {
+        return m.Poster, m.Chat, true
+    }
+    held, err := s.listPending(rm)
+    if err != nil {
+        slog.Error("review queue read failed", "room", rm.ID, "err", err)
+    }
+    for _, p := range held {
+        if p.Chat.ID == msgID {
+            return p.Poster, p.Chat, true
+        }
+    }
+    return poster{}, nil, false
+}
+
+// activeBans returns bans without the mutes that have lapsed by now.
+func activeBans(bans []store.Ban, now time.Time) []store.Ban {
+    return slices.DeleteFunc(slices.Clone(bans), func(b store.Ban) bool {
+        return b.Until != nil && !now.Before(*b.Until)
+    })
+}
+
+// setBans replaces the room's ban list with what edit makes of the stored
+// one. Callers hold rm.mu.
+func (s *Server) setBans(rm *room, edit func(bans []store.Ban) ([]store.Ban, *postError)) *postError {
+    return saveError(s.updateRoom(rm, func(r *store.Room) error {
+        bans, perr := edit(slices.Clone(r.Bans))
+        if perr != nil {
+            return perr
+        }
+        if len(bans) > maxRoomBans {
+            return &postError{Status: http.StatusBadRequest, Msg: "too many bans"}
+        }
+        r.Bans = bans
+        return nil
+    }))
+}
@@ -0,0 +1,205 @@
+package app
+
+// danmakuJS is the comment renderer and room connection shared by
+// /overlay/:roomId and /present. Pages inline it and then call
+//
+//     const dm = SlideFlow.createDanmaku(canvas);
+//     SlideFlow.connectRoom(roomId, msg => { ... dm.push(msg) ... });
+const danmakuJS = `
+(function(){
+  const FONT = "-apple-system, BlinkMacSystemFont, 'Segoe UI', 'Noto Sans JP', 'Hiragino Kaku Gothic ProN', Meiryo, Arial, sans-serif";
+  const SIZES = { small: 24, medium: 36, big: 54 }; // px
+  const SPEED = 160;      // px per second
+  const FIXED_MS = 3000;  // how long ue/shita comments stay
+  const MAX_BULLETS = 200;
+  const GAP = 140;        // min px between scrolling comments in a row
+
+  function createDanmaku(canvas){
+    const ctx = canvas.getContext('2d');
+    let dpr = window.devicePixelRatio || 1;
+    let width = 0, height = 0;
+    let lastTime = performance.now();
+    let visible = true;
+    let maxText = 200;
+    const bullets = [];
+    const inbox = [];
+
+    function resize(){
+      dpr = window.devicePixelRatio || 1;
+      width = Math.floor(window.innerWidth);
+      height = Math.floor(window.innerHeight);
+      canvas.width = Math.floor(width * dpr);
+      canvas.height = Math.floor(height * dpr);
+      canvas.style.width = width + 'px';
+      canvas.style.height = height + 'px';
+      ctx.setTransform(dpr,0,0,dpr,0,0);
+      ctx.textBaseline = 'top';
+    }
+    window.addEventListener('resize', resize);
+    resize();
+
+    function fontOf(px){ return 'bold ' + px + 'px ' + FONT; }
+    function overlaps(b, y, h){ return b.y < y + h && y < b.y + b.h; }
+
+    // Scrolling comments go in the row (stepped by their own height) whose
+    // rightmost comment has travelled furthest, if it has cleared GAP.
+    function placeScroll(item, w, h){
+      let bestY = -1, bestRight = Infinity;
+      for (let y = 0; y + h <= height || y === 0; y += h){
+        let right = -1;
+        for (const b of bullets){
+          if (b.pos === 'naka' && overlaps(b, y, h)) right = Math.max(right, b.x + b.w);
+        }
+        if (right < bestRight){ bestRight = right; bestY = y; }
+      }
+      if (bestY === -1 || bestRight >= width - GAP) return false;
+      bullets.push({ id: item.id, text: item.text, color: item.color, size: item.size, pos: 'naka',
+        x: width, y: bestY, w, h, speed: SPEED });
+      return true;
+    }
+    // ue stacks downwards from the top, shita upwards from the bottom.
+    function placeFixed(item, w, h){
+      const fromTop = item.pos === 'ue';
+      for (let k = 0; k * h + h <= height || k === 0; k++){
+        const y = fromTop ? k * h : height - (k + 1) * h;
+        if (bullets.some(b => b.pos !== 'naka' && overlaps(b, y, h))) continue;
+        bullets.push({ id: item.id, text: item.text, color: item.color, size: item.size, pos: item.pos,
+          x: Math.round((width - w) / 2), y, w, h, until: performance.now() + FIXED_MS });
+        return true;
+      }
+      return false;
+    }
+    function tryPlace(item){
+      if (!item.text) return true;
+      ctx.font = fontOf(item.size);
+      let w = Math.ceil(ctx.measureText(item.text).width);
+      if (item.pos !== 'naka' && w > width){
+        // Fixed comments can't scroll into view, so shrink them to fit.
+        item.size = Math.max(12, Math.floor(item.size * width / w));
+        ctx.font = fontOf(item.size);
+        w = Math.ceil(ctx.measureText(item.text).width);
+      }
+      const h = Math.round(item.size * 1.2);
+      return item.pos === 'naka' ? placeScroll(item, w, h) : placeFixed(item, w, h);
+    }
+
+    function draw(){
+      const now = performance.now();
+      const dt = Math.min(0.05, (now - lastTime) / 1000);
+      lastTime = now;
+      ctx.clearRect(0,0,width,height);
+      if (visible){
+        for (let i=bullets.length-1; i>=0; i--){
+          const b = bullets[i];
+          if (b.pos === 'naka'){
+            b.x -= b.speed * dt;
+            if (b.x + b.w < 0){ bullets.splice(i,1); continue; }
+          } else if (now > b.until){ bullets.splice(i,1); continue; }
+          ctx.save();
+          ctx.font = fontOf(b.size);
+          ctx.shadowColor = b.color === '#000000' ? 'rgba(255,255,255,0.7)' : 'rgba(0,0,0,0.7)';
+          ctx.shadowBlur = 4; ctx.shadowOffsetX = 2; ctx.shadowOffsetY = 2;
+          ctx.fillStyle = b.color || '#fff';
+          ctx.fillText(b.text, Math.round(b.x), b.y + Math.round((b.h - b.size) / 2));
+          ctx.restore();
+        }
+        for (let i=0; i<inbox.length && bullets.length < MAX_BULLETS; ){
+          if (tryPlace(inbox[i])) { inbox.splice(i,1); }
+          else { i++; }
+        }
+      }
+      requestAnimationFrame(draw);
+    }
+    requestAnimationFrame(draw);
+
+    return {
+      // push queues a chat event for display.
+      push(msg){
+        const txt = String(msg.text || '').slice(0, maxText);
+        const handle = (msg.handle ? String(msg.handle) : '').trim();
+        inbox.push({
+          id: msg.id,
+          text: handle ? '【' + handle + '】 ' + txt : txt,
+          color: msg.color || '#ffffff',
+          size: SIZES[msg.size] || SIZES.medium,
+          pos: (msg.position === 'ue' || msg.position === 'shita') ? msg.position : 'naka',
+        });
+      },
+      clear(){ bullets.length = 0; inbox.length = 0; },
+      // remove takes one comment, by chat event id, off screen or out of
+      // the queue.
+      remove(id){
+        for (const list of [bullets, inbox]){
+          for (let i=list.length-1; i>=0; i--){ if (list[i].id === id) list.splice(i,1); }
+        }
+      },
+      // configure applies a config event from the server.
+      configure(cfg){ if (cfg.maxTextLen > 0) maxText = cfg.maxTextLen; },
+      setVisible(v){ visible = !!v; },
+      isVisible(){ return visible; },
+    };
+  }
+
+  // connectRoom keeps a connection to the room open, resuming from the
+  // last seen seq after a drop, and hands every parsed event to onEvent.
+  // It uses a WebSocket, and switches to Server-Sent Events for good once
+  // WS_FAILURES sockets in a row have failed to open, as they do behind
+  // proxies that block upgrades.
+  const WS_FAILURES = 3;
+  function connectRoom(roomId, onEvent){
+    const wsProto = (location.protocol === 'https:') ? 'wss' : 'ws';
+    const wsUrl = wsProto + '://' + location.host + '/ws/' + roomId;
+    const sseUrl = '/events/' + roomId;
+    let epoch = '';   // run of seqs lastSeq belongs to
+    let lastSeq = 0;  // last chat seq seen, sent as ?since= on reconnect
+    let failures = 0; // sockets in a row that never opened
+    function handle(data){
+      let msg;
+      try { msg = JSON.parse(data); } catch(e){ return; }
+      if (!msg) return;
+      if (msg.seq) {
+        // a new epoch means the room's seqs started over (the server
+        // restarted), so ours no longer compare
+        if (msg.epoch !== epoch) { epoch = msg.epoch || ''; lastSeq = msg.seq; }
+        else if (msg.seq > lastSeq) lastSeq = msg.seq;
+      }
+      onEvent(msg);
+    }
+    function since(){
+      return '?since=' + encodeURIComponent(epoch ? epoch + ':' + lastSeq : String(lastSeq));
+    }
+    function connect(){
+      const ws = new WebSocket(lastSeq ? wsUrl + since() : wsUrl);
+      let opened = false;
+      ws.addEventListener('open', ()=> { opened = true; failures = 0; });
+      ws.addEventListener('message', (ev)=> handle(ev.data));
+      ws.addEventListener('close', (ev)=> {
+        // 4000: room closed or expired, reconnecting won't help
+        if (ev.code === 4000) return;
+        if (!opened && ++failures >= WS_FAILURES) { stream(); return; }
+        setTimeout(connect, 1000);
+      });
+      ws.addEventListener('error', ()=> { try{ ws.close(); }catch{} });
+    }
+    // stream is the SSE fallback. EventSource reconnects by itself and
+    // resumes through Last-Event-ID, but gives up on an error status such
+    // as a restarting server's 503, so then we start over.
+    function stream(){
+      const es = new EventSource(lastSeq ? sseUrl + since() : sseUrl);
+      let done = false;
+      es.addEventListener('message', (ev)=> handle(ev.data));
+      es.addEventListener('close', (ev)=> {
+        let info = {};
+        try { info = JSON.parse(ev.data); } catch(e){}
+        if (info.code === 4000) { done = true; es.close(); }
+      });
+      es.addEventListener('error', ()=> {
+        if (!done && es.readyState === EventSource.CLOSED) setTimeout(stream, 1000);
+      });
+    }
+    connect();
+  }
+
+  window.SlideFlow = { createDanmaku, connectRoom };
+})();
+`
@@ -1,10 +1,14 @@
 package app
 
 import (
+    "encoding/json"
     "fmt"
+    "log/slog"
     "net/http"
     "strings"
     "time"
+
+    "slideflow/internal/util"
 )
 
 // GET /post/:roomId -> simple HTML form to submit messages
@@ -14,13 +18,16 @@ func (s *Server) handlePostForm(w http.ResponseWriter, r *http.Request) {
         return
     }
     roomID := strings.TrimPrefix(r.URL.Path, "/post/")
-    s.mu.Lock()
-    _, ok := s.rooms[roomID]
-    s.mu.Unlock()
+    util.LogAttrs(r.Context(), slog.String("room", roomID))
+    rm, ok := s.lookupRoom(roomID)
     if !ok {
         http.Error(w, "room not found", http.StatusNotFound)
         return
     }
+    rm.mu.Lock()
+    cfg, _ := json.Marshal(rm.configEvent())
+    rm.mu.Unlock()
+    s.ensureParticipant(w, r)
     w.Header().Set("Content-Type", "text/html; charset=utf-8")
     fmt.Fprintf(w, `<!doctype html>
 <html lang="ja">
@@ -33,7 +40,7 @@ func (s *Server) handlePostForm(w http.ResponseWriter, r *http.Request) {
     body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', 'Noto Sans JP', 'Hiragino Kaku Gothic ProN', Meiryo, Arial, sans-serif; margin: 24px; }
     .wrap { max-width: 640px; margin: 0 auto; }
     label { display:block; margin: 12px 0 6px; font-weight: 600; }
-    input, textarea, button { width:100%%; font-size:16px; padding:10px; box-sizing:border-box; }
+    input, textarea, select, button { width:100%%; font-size:16px; padding:10px; box-sizing:border-box; }
     textarea { height: 120px; resize: vertical; }
     .row { display:flex; gap:12px; align-items:center; }
     .row > * { flex: 1; }
@@ -47,14 +54,37 @@ func (s *Server) handlePostForm(w http.ResponseWriter, r *http.Request) {
     <h1>コメント投稿</h1>
     <p class="hint">ルームID: <code>%s</code></p>
     <form id="msgForm">
-      <label for="handle">ハンドルネーム（任意・32文字まで）</label>
-      <input id="handle" name="handle" maxlength="32" placeholder="例: alice" />
+      <label for="handle" id="handleLabel">ハンドルネーム</label>
+      <input id="handle" name="handle" placeholder="例: alice" />
 
-      <label for="text">コメント（必須・200文字まで）</label>
-      <textarea id="text" name="text" maxlength="200" placeholder="今のスライドに一言！"></textarea>
+      <label for="text" id="textLabel">コメント</label>
+      <textarea id="text" name="text" placeholder="今のスライドに一言！"></textarea>
+      <div class="row">
+        <div id="colorBox">
+          <label for="color">色</label>
+          <select id="color">
+            <option value="white">白</option><option value="red">赤</option><option value="pink">ピンク</option>
+            <option value="orange">オレンジ</option><option value="yellow">黄</option><option value="green">緑</option>
+            <option value="cyan">水色</option><option value="blue">青</option><option value="purple">紫</option>
+            <option value="black">黒</option>
+          </select>
+        </div>
+        <div id="sizeBox">
+          <label for="size">サイズ</label>
+          <select id="size">
+            <option value="small">小</option><option value="medium" selected>中</option><option value="big">大</option>
+          </select>
+        </div>
+        <div id="positionBox">
+          <label for="position">位置</label>
+          <select id="position">
+            <option value="naka">流れる</option><option value="ue">上に固定</option><option value="shita">下に固定</option>
+          </select>
+        </div>
+      </div>
       <div class="row">
-        <div class="hint" id="counter">0 / 200</div>
-        <div class="hint">送信後、すぐにスクリーンへ流れます。</div>
+        <div class="hint" id="counter"></div>
+        <div class="hint" id="flowHint">送信後、すぐにスクリーンへ流れます。</div>
       </div>
       <button id="submitBtn" type="submit">送信</button>
       <div class="status" id="status"></div>
@@ -63,19 +93,35 @@ func (s *Server) handlePostForm(w http.ResponseWriter, r *http.Request) {
   <script>
   (function(){
     const roomId = %q;
+    const cfg = %s; // room settings, see event.Config
     const form = document.getElementById('msgForm');
     const text = document.getElementById('text');
     const handle = document.getElementById('handle');
+    const color = document.getElementById('color');
+    const size = document.getElementById('size');
+    const position = document.getElementById('position');
     const counter = document.getElementById('counter');
     const status = document.getElementById('status');
     const submitBtn = document.getElementById('submitBtn');
 
-    text.addEventListener('input', ()=>{
-      const n = (text.value||'').length; counter.textContent = n + ' / 200';
-    });
+    text.maxLength = cfg.maxTextLen;
+    handle.maxLength = cfg.maxHandleLen;
+    handle.required = !cfg.allowAnonymous;
+    document.getElementById('textLabel').textContent = 'コメント（必須・' + cfg.maxTextLen + '文字まで）';
+    document.getElementById('handleLabel').textContent = 'ハンドルネーム（' + (cfg.allowAnonymous ? '任意' : '必須') + '・' + cfg.maxHandleLen + '文字まで）';
+    for (const k of ['color', 'size', 'position']){
+      if (!cfg.allowedStyles.includes(k)) document.getElementById(k + 'Box').style.display = 'none';
+    }
+    if (cfg.reviewMode) document.getElementById('flowHint').textContent = 'モデレーターの確認後にスクリーンへ流れます。';
+    function updateCounter(){ counter.textContent = (text.value||'').length + ' / ' + cfg.maxTextLen; }
+    updateCounter();
+    text.addEventListener('input', updateCounter);
     form.addEventListener('submit', async (e)=>{
       e.preventDefault();
       const payload = { text: (text.value||'').trim(), handle: (handle.value||'').trim() };
+      if (cfg.allowedStyles.includes('color')) payload.color = color.value;
+      if (cfg.allowedStyles.includes('size')) payload.size = size.value;
+      if (cfg.allowedStyles.includes('position')) payload.position = position.value;
       if (!payload.text){ status.textContent = 'テキストは必須です'; return; }
       submitBtn.disabled = true;
       try {
@@ -83,33 +129,58 @@ func (s *Server) handlePostForm(w http.ResponseWriter, r *http.Request) {
           method: 'POST', headers: { 'Content-Type': 'application/json' },
           body: JSON.stringify(payload)
         });
-        if (res.ok) { status.textContent = '送信しました'; text.value = ''; counter.textContent='0 / 200'; }
-        else { status.textContent = 'エラー: ' + await res.text(); }
+        if (res.ok) {
+          const out = await res.json();
+          status.textContent = out.action === 'held' ? '確認待ちです（モデレーター承認後に表示されます）'
+            : out.action === 'masked' ? '一部を伏字にして送信しました: ' + out.text
+            : '送信しました';
+          text.value = ''; updateCounter();
+        }
+        else {
+          const msg = (await res.text()).trim();
+          status.textContent = msg === 'banned' ? 'このルームへの投稿は禁止されています'
+            : msg === 'muted' ? 'しばらくの間、投稿が制限されています'
+            : msg === 'server shutting down' ? 'サーバーを再起動しています。少し待ってからもう一度送信してください'
+            : 'エラー: ' + msg;
+        }
       } catch(e){ status.textContent = 'ネットワークエラー'; }
       finally { submitBtn.disabled = false; }
     });
   })();
   </script>
 </body>
-</html>`, roomID, roomID, roomID)
+</html>`, roomID, roomID, roomID, cfg)
 }
 
-// GET /admin/:roomId -> simple admin controls
+// GET /admin/:roomId -> simple admin controls (admin token or cookie required)
 func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
         http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
         return
     }
     roomID := strings.TrimPrefix(r.URL.Path, "/admin/")
-    s.mu.Lock()
-    rm, ok := s.rooms[roomID]
-    s.mu.Unlock()
+    util.LogAttrs(r.Context(), slog.String("room", roomID))
+    rm, ok := s.lookupRoom(roomID)
     if !ok {
         http.Error(w, "room not found", http.StatusNotFound)
         return
     }
+    if !requireAdmin(w, r, rm) {
+        return
+    }
+    // Exchange a token in the URL for the signed cookie and drop it from the
+    // address bar, so it doesn't leak via screen sharing or history.
+    if r.URL.Query().Get("token") != "" {
+        s.setAdminCookie(w, r, rm)
+        w.Header().Set("Cache-Control", "no-store")
+        http.Redirect(w, r, "/admin/"+roomID, http.StatusSeeOther)
+        return
+    }
+    rm.mu.Lock()
     paused := rm.Paused
     slowMs := int(rm.SlowMode / time.Millisecond)
+    settings, _ := json.Marshal(rm.Settings)
+    rm.mu.Unlock()
     w.Header().Set("Content-Type", "text/html; charset=utf-8")
     fmt.Fprintf(w, `<!doctype html>
 <html lang="ja">
@@ -124,7 +195,9 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
     h1 { margin-bottom: 4px; }
     .hint { color: #888; font-size: 12px; margin-bottom: 16px; }
     label { display:block; margin: 12px 0 6px; font-weight: 600; }
-    input, button { font-size:16px; padding:10px; }
+    input, select, button { font-size:16px; padding:10px; }
+    fieldset { margin-top: 20px; border: 1px solid #8884; border-radius: 6px; }
+    .check { display:inline-flex; gap:4px; align-items:center; font-weight: normal; margin: 0 12px 0 0; }
     .row { display:flex; gap:12px; align-items:center; }
     .status { margin-top: 12px; min-height: 1.4em; }
     button { cursor: pointer; }
@@ -138,12 +211,71 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
       <button id="pauseBtn">一時停止</button>
       <button id="resumeBtn">再開</button>
       <button id="clearBtn">全消去</button>
+      <button id="closeBtn">ルームを閉じる</button>
     </div>
     <label for="slow">スローモード（ミリ秒）</label>
     <div class="row">
       <input id="slow" type="number" min="0" step="100" value="%d" />
       <button id="applySlow">適用</button>
     </div>
+    <fieldset>
+      <legend>ルーム設定</legend>
+      <div class="row">
+        <div><label for="maxTextLen">コメント最大文字数</label><input id="maxTextLen" type="number" min="1" max="1000" /></div>
+        <div><label for="maxHandleLen">ハンドル最大文字数</label><input id="maxHandleLen" type="number" min="1" max="64" /></div>
+      </div>
+      <div class="row">
+        <div><label for="cooldownMs">連投間隔（ミリ秒）</label><input id="cooldownMs" type="number" min="0" step="100" /></div>
+        <div><label for="ngMode">NGワード</label>
+          <select id="ngMode">
+            <option value="both">共通＋ルーム</option><option value="global">共通のみ</option>
+            <option value="room">ルームのみ</option><option value="off">無効</option>
+          </select>
+        </div>
+        <div><label for="ngGlobalAction">共通リスト該当時</label>
+          <select id="ngGlobalAction">
+            <option value="reject">拒否</option><option value="mask">伏字</option><option value="hold">保留</option>
+          </select>
+        </div>
+      </div>
+      <label class="check"><input id="allowAnonymous" type="checkbox" /> 匿名（ハンドルなし）を許可</label>
+      <label class="check"><input id="reviewMode" type="checkbox" /> 全コメントを確認してから表示</label>
+      <label>使用可能なコマンド</label>
+      <label class="check"><input type="checkbox" name="style" value="color" /> 色</label>
+      <label class="check"><input type="checkbox" name="style" value="size" /> サイズ</label>
+      <label class="check"><input type="checkbox" name="style" value="position" /> 位置</label>
+      <div class="row" style="margin-top:12px"><button id="saveSettings">設定を保存</button></div>
+    </fieldset>
+    <fieldset>
+      <legend>ルームのNGワード</legend>
+      <p class="hint">全角/半角・カタカナ/ひらがな・大文字/小文字・空白や記号の挿入は同一視して判定します。</p>
+      <ul id="ngList"></ul>
+      <div class="row">
+        <select id="ngKind">
+          <option value="substring">部分一致</option><option value="word">単語一致</option><option value="regex">正規表現</option>
+        </select>
+        <select id="ngAction">
+          <option value="reject">拒否</option><option value="mask">伏字</option><option value="hold">保留</option>
+        </select>
+        <input id="ngPattern" placeholder="NGワード" />
+        <button id="ngAdd">追加</button>
+      </div>
+    </fieldset>
+    <fieldset>
+      <legend>確認待ちコメント <span id="pendingCount"></span></legend>
+      <p class="hint">承認したコメントだけがスクリーンに流れます。本文を書き換えてから承認することもできます。</p>
+      <ul id="pendingList"></ul>
+    </fieldset>
+    <fieldset>
+      <legend>最近のコメント</legend>
+      <p class="hint">削除すると、表示中のスクリーンからも消えます。</p>
+      <ul id="recentList"></ul>
+    </fieldset>
+    <fieldset>
+      <legend>投稿禁止・ミュート</legend>
+      <p class="hint">最近のコメントの「ミュート」「BAN」から追加できます。同じIPとハンドルの組み合わせからの投稿を止めます。</p>
+      <ul id="banList"></ul>
+    </fieldset>
     <div class="status" id="status"></div>
   </div>
   <script>
@@ -153,9 +285,175 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
     const pauseBtn = document.getElementById('pauseBtn');
     const resumeBtn = document.getElementById('resumeBtn');
     const clearBtn = document.getElementById('clearBtn');
+    const closeBtn = document.getElementById('closeBtn');
     const slow = document.getElementById('slow');
     const applySlow = document.getElementById('applySlow');
     let paused = %t;
+    const settings = %s;
+    const $ = (id)=> document.getElementById(id);
+    const styleBoxes = Array.from(document.querySelectorAll('input[name=style]'));
+    function showSettings(st){
+      $('maxTextLen').value = st.maxTextLen;
+      $('maxHandleLen').value = st.maxHandleLen;
+      $('cooldownMs').value = st.cooldownMs;
+      $('ngMode').value = st.ngMode;
+      $('ngGlobalAction').value = st.ngGlobalAction || 'reject';
+      $('allowAnonymous').checked = st.allowAnonymous;
+      $('reviewMode').checked = !!st.reviewMode;
+      styleBoxes.forEach(b => { b.checked = st.allowedStyles.includes(b.value); });
+    }
+    showSettings(settings);
+
+    const kindLabels = { substring: '部分一致', word: '単語一致', regex: '正規表現' };
+    const actionLabels = { reject: '拒否', mask: '伏字', hold: '保留' };
+    function showNG(rules){
+      const ul = $('ngList');
+      ul.textContent = '';
+      for (const rule of rules){
+        const li = document.createElement('li');
+        li.textContent = '[' + (kindLabels[rule.kind] || rule.kind) + '/' + actionLabels[rule.action || 'reject'] + '] ' + rule.pattern + ' ';
+        const del = document.createElement('button');
+        del.textContent = '削除';
+        del.addEventListener('click', async ()=>{
+          const res = await fetch('/rooms/' + roomId + '/ngwords/' + encodeURIComponent(rule.id), { method:'DELETE' });
+          if (res.ok){ showNG((await res.json()).rules); } else setStatus('エラー: ' + await res.text());
+        });
+        li.appendChild(del);
+        ul.appendChild(li);
+      }
+    }
+    fetch('/rooms/' + roomId + '/ngwords').then(r => r.json()).then(d => showNG(d.rules)).catch(()=>{});
+    $('ngAdd').addEventListener('click', async ()=>{
+      const pattern = ($('ngPattern').value||'').trim();
+      if (!pattern) return;
+      const res = await post('ngwords', { kind: $('ngKind').value, pattern, action: $('ngAction').value });
+      if (res.ok){ $('ngPattern').value = ''; showNG((await res.json()).rules); setStatus('NGワードを追加しました'); }
+      else setStatus('エラー: ' + await res.text());
+    });
+
+    // Review queue: loaded once, then kept current by the admin socket.
+    const pendingItems = new Map();
+    function updatePendingCount(){ $('pendingCount').textContent = pendingItems.size ? '(' + pendingItems.size + ')' : ''; }
+    function addPending(p){
+      const chat = p.chat;
+      if (pendingItems.has(chat.id)) return;
+      const li = document.createElement('li');
+      const who = document.createElement('span');
+      who.textContent = (chat.handle || '匿名') + '（' + p.reason + '）: ';
+      const edit = document.createElement('input');
+      edit.value = chat.text;
+      const ok = document.createElement('button');
+      ok.textContent = '承認';
+      ok.addEventListener('click', async ()=>{
+        const body = edit.value.trim() !== chat.text ? { text: edit.value } : null;
+        const res = await post('pending/' + encodeURIComponent(chat.id) + '/approve', body);
+        if (res.ok){ removePending(chat.id); setStatus('承認しました'); } else setStatus('エラー: ' + await res.text());
+      });
+      const ng = document.createElement('button');
+      ng.textContent = '却下';
+      ng.addEventListener('click', async ()=>{
+        const res = await post('pending/' + encodeURIComponent(chat.id) + '/reject');
+        if (res.ok){ removePending(chat.id); setStatus('却下しました'); } else setStatus('エラー: ' + await res.text());
+      });
+      li.append(who, edit, ok, ng);
+      $('pendingList').appendChild(li);
+      pendingItems.set(chat.id, li);
+      updatePendingCount();
+    }
+    function removePending(id){
+      const li = pendingItems.get(id);
+      if (li){ li.remove(); pendingItems.delete(id); updatePendingCount(); }
+    }
+    function loadPending(){
+      fetch('/rooms/' + roomId + '/pending').then(r => r.json()).then(d => d.pending.forEach(addPending)).catch(()=>{});
+    }
+    // Recently shown comments, newest first.
+    const recentItems = new Map();
+    const MAX_RECENT = 200;
+    function addRecent(chat){
+      if (recentItems.has(chat.id)) return;
+      const li = document.createElement('li');
+      li.textContent = (chat.handle || '匿名') + ': ' + chat.text + ' ';
+      const del = document.createElement('button');
+      del.textContent = '削除';
+      del.addEventListener('click', async ()=>{
+        const res = await fetch('/rooms/' + roomId + '/messages/' + encodeURIComponent(chat.id), { method:'DELETE' });
+        if (res.ok){ removeRecent(chat.id); setStatus('コメントを削除しました'); } else setStatus('エラー: ' + await res.text());
+      });
+      const mute = document.createElement('button');
+      mute.textContent = 'ミュート10分';
+      mute.addEventListener('click', ()=> ban(chat, 10 * 60 * 1000));
+      const bang = document.createElement('button');
+      bang.textContent = 'BAN';
+      bang.addEventListener('click', ()=>{ if (confirm('この参加者の投稿を禁止しますか？')) ban(chat, 0); });
+      li.append(del, mute, bang);
+      $('recentList').prepend(li);
+      recentItems.set(chat.id, li);
+      if (recentItems.size > MAX_RECENT){
+        const [oldest] = recentItems.keys();
+        removeRecent(oldest);
+      }
+    }
+    function removeRecent(id){
+      const li = recentItems.get(id);
+      if (li){ li.remove(); recentItems.delete(id); }
+    }
+    function loadRecent(){
+      fetch('/rooms/' + roomId + '/messages').then(r => r.json()).then(d => d.messages.forEach(m => addRecent(m.chat))).catch(()=>{});
+    }
+    const banKindLabels = { identity: 'IP+ハンドル', ip: 'IP', participant: '端末' };
+    async function ban(chat, durationMs){
+      const res = await post('bans', { messageId: chat.id, durationMs });
+      if (res.ok){ showBans((await res.json()).bans); setStatus(durationMs ? 'ミュートしました' : 'BANしました'); }
+      else setStatus('エラー: ' + await res.text());
+    }
+    function showBans(bans){
+      const ul = $('banList');
+      ul.textContent = '';
+      for (const b of bans){
+        const li = document.createElement('li');
+        const until = b.until ? new Date(b.until).toLocaleTimeString() + 'までミュート' : 'BAN';
+        li.textContent = (b.label || b.value) + '（' + (banKindLabels[b.kind] || b.kind) + '・' + until + '） ';
+        const lift = document.createElement('button');
+        lift.textContent = '解除';
+        lift.addEventListener('click', async ()=>{
+          const res = await fetch('/rooms/' + roomId + '/bans/' + encodeURIComponent(b.id), { method:'DELETE' });
+          if (res.ok){ showBans((await res.json()).bans); setStatus('解除しました'); } else setStatus('エラー: ' + await res.text());
+        });
+        li.appendChild(lift);
+        if (b.messages.length){
+          const msgs = document.createElement('ul');
+          for (const m of b.messages){
+            const mi = document.createElement('li');
+            mi.textContent = m.text;
+            msgs.appendChild(mi);
+          }
+          li.appendChild(msgs);
+        }
+        ul.appendChild(li);
+      }
+    }
+    function loadBans(){
+      fetch('/rooms/' + roomId + '/bans').then(r => r.json()).then(d => showBans(d.bans)).catch(()=>{});
+    }
+    loadBans();
+
+    function connectAdmin(){
+      const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+      const ws = new WebSocket(proto + '//' + location.host + '/ws/' + roomId);
+      // Catch up on anything queued while we were disconnected.
+      ws.onopen = ()=>{ loadPending(); loadRecent(); };
+      ws.onmessage = (e)=>{
+        let ev; try { ev = JSON.parse(e.data); } catch(_) { return; }
+        if (ev.type === 'pending') addPending(ev);
+        else if (ev.type === 'review') removePending(ev.msgId);
+        else if (ev.type === 'chat') addRecent(ev);
+        else if (ev.type === 'retract') removeRecent(ev.msgId);
+        else if (ev.type === 'system') setStatus(ev.text);
+      };
+      ws.onclose = (e)=>{ if (e.code !== 4000) setTimeout(connectAdmin, 1000); };
+    }
+    connectAdmin();
 
     function setStatus(t){ status.textContent = t; }
     function post(path, body){
@@ -177,6 +475,28 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
       const res = await post('clear');
       if (res.ok){ setStatus('全消去を送信しました'); } else setStatus('エラー: ' + await res.text());
     });
+    closeBtn.addEventListener('click', async ()=>{
+      if (!confirm('ルームを閉じると全ての接続が切断され、URL/QRも無効になります。よろしいですか？')) return;
+      const res = await fetch('/rooms/' + roomId, { method:'DELETE' });
+      if (res.ok){ setStatus('ルームを閉じました'); } else setStatus('エラー: ' + await res.text());
+    });
+    $('saveSettings').addEventListener('click', async ()=>{
+      const body = {
+        maxTextLen: parseInt($('maxTextLen').value, 10),
+        maxHandleLen: parseInt($('maxHandleLen').value, 10),
+        cooldownMs: parseInt($('cooldownMs').value||'0', 10) || 0,
+        ngMode: $('ngMode').value,
+        ngGlobalAction: $('ngGlobalAction').value,
+        allowAnonymous: $('allowAnonymous').checked,
+        reviewMode: $('reviewMode').checked,
+        allowedStyles: styleBoxes.filter(b => b.checked).map(b => b.value),
+      };
+      const res = await fetch('/rooms/' + roomId + '/settings', {
+        method:'PATCH', headers:{'Content-Type':'application/json'}, body: JSON.stringify(body)
+      });
+      if (res.ok){ showSettings((await res.json()).settings); setStatus('設定を保存しました'); }
+      else setStatus('エラー: ' + await res.text());
+    });
     applySlow.addEventListener('click', async ()=>{
       const ms = parseInt(slow.value||'0', 10) || 0;
       const res = await post('slowmode', {ms});
@@ -185,6 +505,6 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
   })();
   </script>
 </body>
-</html>`, roomID, roomID, slowMs, roomID, paused)
+</html>`, roomID, roomID, slowMs, roomID, paused, settings)
 }
 
@@ -0,0 +1,123 @@
+package app
+
+import (
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strings"
+    "time"
+
+    "slideflow/internal/event"
+    "slideflow/internal/hub"
+    "slideflow/internal/util"
+)
+
+// sseKeepAlive is how often an idle event stream gets a comment line, so
+// proxies don't time it out.
+const sseKeepAlive = 25 * time.Second
+
+// GET /events/:roomId[?since=epoch:seq]
+// The room's events as Server-Sent Events, for networks that block
+// WebSocket upgrades. Each event's data is the same JSON as a /ws/:roomId
+// frame. Chat events carry "epoch:seq" as the event id, so a reconnecting
+// EventSource resumes through Last-Event-ID, which wins over since. When
+// the server lets the client go it sends a "close" event with
+// {code, reason} first; after code 4000 the client should not reconnect.
+// The stream is receive-only.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if s.refuseDraining(w) {
+        return
+    }
+    roomID := strings.TrimPrefix(r.URL.Path, "/events/")
+    util.LogAttrs(r.Context(), slog.String("room", roomID))
+    rm, ok := s.lookupRoom(roomID)
+    if !ok {
+        http.Error(w, "room not found", http.StatusNotFound)
+        return
+    }
+
+    var epoch string
+    var since uint64
+    resume := false
+    v := r.Header.Get("Last-Event-ID")
+    if v == "" {
+        v = r.URL.Query().Get("since")
+    }
+    if v != "" {
+        var err error
+        if epoch, since, err = parseSince(v); err != nil {
+            http.Error(w, "invalid since", http.StatusBadRequest)
+            return
+        }
+        resume = true
+    }
+
+    rc := http.NewResponseController(w)
+    w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no") // nginx: don't buffer the stream
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprint(w, "retry: 1000\n\n")
+    if err := rc.Flush(); err != nil {
+        return
+    }
+
+    rm.mu.Lock()
+    rm.LastActive = time.Now()
+    rm.mu.Unlock()
+
+    client := hub.NewStream(rm.Hub)
+    if resume {
+        client.ResumeFrom(epoch, since)
+    }
+    rm.Hub.RegisterClient(client)
+    defer rm.Hub.UnregisterClient(client)
+    s.follow(rm)
+    rm.mu.Lock()
+    cfg := rm.configEvent()
+    rm.mu.Unlock()
+    client.Reply(event.Encode(cfg))
+
+    ping := time.NewTicker(sseKeepAlive)
+    defer ping.Stop()
+    for {
+        select {
+        case <-client.Ready():
+            sseDeadline(rc)
+            frames, closed, code, reason := client.Take()
+            for _, f := range frames {
+                if f.Seq > 0 {
+                    fmt.Fprintf(w, "id: %s:%d\n", rm.Hub.Epoch(), f.Seq)
+                }
+                fmt.Fprintf(w, "data: %s\n\n", f.Data)
+            }
+            if closed && code != 0 {
+                b, _ := json.Marshal(map[string]any{"code": code, "reason": reason})
+                fmt.Fprintf(w, "event: close\ndata: %s\n\n", b)
+            }
+            if rc.Flush() != nil || closed {
+                return
+            }
+        case <-ping.C:
+            sseDeadline(rc)
+            fmt.Fprint(w, ": ping\n\n")
+            if rc.Flush() != nil {
+                return
+            }
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+// sseDeadline gives the next batch of writes its own deadline, as
+// WebSocket writes have; the server's WriteTimeout would otherwise end
+// the stream.
+func sseDeadline(rc *http.ResponseController) {
+    rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+}
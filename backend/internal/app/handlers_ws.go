@@ -1,42 +1,155 @@
 package app
 
 import (
-    "log"
+    "encoding/json"
+    "errors"
+    "log/slog"
     "net/http"
+    "strconv"
     "strings"
+    "time"
 
     "github.com/gorilla/websocket"
+    "slideflow/internal/event"
     "slideflow/internal/hub"
+    "slideflow/internal/util"
 )
 
-// GET /ws/:roomId
+// GET /ws/:roomId[?since=epoch:seq]
+// With since, chat frames the client missed (seq > since) are replayed
+// before live ones; all of them if the room's seqs have started over
+// since (a new epoch). Connections are receive-only unless they present the
+// room's admin token or cookie; see handleSocketMessage for what those may
+// send. Admin sockets also receive the review queue's pending/review events.
 func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
         http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
         return
     }
+    if s.refuseDraining(w) {
+        return
+    }
     roomID := strings.TrimPrefix(r.URL.Path, "/ws/")
-    s.mu.Lock()
-    rm, ok := s.rooms[roomID]
-    s.mu.Unlock()
+    util.LogAttrs(r.Context(), slog.String("room", roomID))
+    rm, ok := s.lookupRoom(roomID)
     if !ok {
         http.Error(w, "room not found", http.StatusNotFound)
         return
     }
 
+    // A wrong token is refused outright; no token means a viewer socket.
+    admin := false
+    switch checkAdmin(r, rm) {
+    case 0:
+        admin = true
+    case http.StatusForbidden:
+        http.Error(w, "invalid admin token", http.StatusForbidden)
+        return
+    }
+
+    var epoch string
+    var since uint64
+    resume := false
+    if v := r.URL.Query().Get("since"); v != "" {
+        var err error
+        if epoch, since, err = parseSince(v); err != nil {
+            http.Error(w, "invalid since", http.StatusBadRequest)
+            return
+        }
+        resume = true
+    }
+
     upgrader := websocket.Upgrader{
         ReadBufferSize:  1024,
         WriteBufferSize: 1024,
-        CheckOrigin: func(r *http.Request) bool { return true },
+        // Viewers may embed the overlay anywhere, but an admin socket can
+        // post, so it must not be opened by another site riding on the
+        // admin cookie.
+        CheckOrigin: func(r *http.Request) bool { return !admin || s.sameOrigin(r) },
     }
 
     conn, err := upgrader.Upgrade(w, r, nil)
     if err != nil {
-        log.Printf("ws upgrade error: %v", err)
+        util.Logger(r.Context()).Warn("ws upgrade failed", "room", roomID, "err", err)
         return
     }
 
+    rm.mu.Lock()
+    rm.LastActive = time.Now()
+    rm.mu.Unlock()
+
     client := hub.NewClient(rm.Hub, conn)
+    if resume {
+        client.ResumeFrom(epoch, since)
+    }
+    if admin {
+        client.SetAdmin()
+        client.AcceptMessages(func(c *hub.Client, data []byte) {
+            s.handleSocketMessage(c, r, rm, data)
+        })
+    }
     rm.Hub.RegisterClient(client)
+    s.follow(rm)
+    // Start every client off with the room's current settings.
+    rm.mu.Lock()
+    cfg := rm.configEvent()
+    rm.mu.Unlock()
+    client.Reply(event.Encode(cfg))
     client.Start()
 }
+
+// parseSince reads a resume position, "<epoch>:<seq>" as built from chat
+// events or a bare seq as sent by older clients.
+func parseSince(v string) (epoch string, seq uint64, err error) {
+    if e, n, ok := strings.Cut(v, ":"); ok {
+        if e == "" {
+            return "", 0, errors.New("empty epoch")
+        }
+        epoch, v = e, n
+    }
+    seq, err = strconv.ParseUint(v, 10, 64)
+    return epoch, seq, err
+}
+
+// socketMessage is an inbound frame from a client allowed to send.
+//
+//     {"type":"chat","ref":"1","text":"...","handle":"..."}
+//
+// Each frame is answered on the same socket with an event.Ack or an
+// event.Error carrying the same ref.
+type socketMessage struct {
+    Type string `json:"type"`
+    Ref  string `json:"ref,omitempty"`
+    postMessageReq
+}
+
+func (s *Server) handleSocketMessage(c *hub.Client, r *http.Request, rm *room, data []byte) {
+    var msg socketMessage
+    if err := json.Unmarshal(data, &msg); err != nil {
+        c.Reply(socketReply(rm, msg.Ref, &postError{Status: http.StatusBadRequest, Msg: "invalid json"}))
+        return
+    }
+    switch msg.Type {
+    case "chat":
+        // Same pipeline as POST /rooms/{id}/messages
+        res, perr := s.submitMessage(r, rm, msg.postMessageReq, true)
+        if perr != nil {
+            c.Reply(socketReply(rm, msg.Ref, perr))
+            return
+        }
+        ack := event.NewAck(rm.ID, msg.Ref)
+        ack.Action, ack.Text = res.Action, res.Text
+        c.Reply(event.Encode(ack))
+    default:
+        c.Reply(socketReply(rm, msg.Ref, &postError{Status: http.StatusBadRequest, Msg: "unknown type"}))
+    }
+}
+
+func socketReply(rm *room, ref string, perr *postError) []byte {
+    if perr != nil {
+        ev := event.NewError(rm.ID, ref, perr.Status, perr.Msg)
+        ev.RetryAfterMs = perr.RetryAfter.Milliseconds()
+        return event.Encode(ev)
+    }
+    return event.Encode(event.NewAck(rm.ID, ref))
+}
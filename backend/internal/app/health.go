@@ -0,0 +1,92 @@
+package app
+
+import (
+    "encoding/json"
+    "net/http"
+    "runtime"
+    "time"
+)
+
+// check is one dependency's result in /readyz.
+type check struct {
+    OK        bool    `json:"ok"`
+    LatencyMs float64 `json:"latencyMs"`
+    Error     string  `json:"error,omitempty"`
+}
+
+func runCheck(ping func() error) check {
+    start := time.Now()
+    err := ping()
+    c := check{OK: err == nil, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+    if err != nil {
+        c.Error = err.Error()
+    }
+    return c
+}
+
+// counts are the process numbers both endpoints report.
+type counts struct {
+    Goroutines int     `json:"goroutines"`
+    Rooms      int     `json:"rooms"`
+    Clients    int     `json:"clients"`
+    UptimeSec  float64 `json:"uptimeSec"`
+}
+
+func (s *Server) counts() counts {
+    c := counts{Goroutines: runtime.NumGoroutine(), UptimeSec: time.Since(s.started).Seconds()}
+    for _, rm := range s.rooms.all() {
+        c.Rooms++
+        c.Clients += rm.Hub.ClientCount()
+    }
+    return c
+}
+
+// GET /healthz -> 200 { status: "ok", goroutines, rooms, clients, uptimeSec }
+// Liveness: the process is up and serving. It stays 200 while draining.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    writeHealth(w, http.StatusOK, struct {
+        Status string `json:"status"`
+        counts
+    }{"ok", s.counts()})
+}
+
+// GET /readyz -> 200 or 503 { status, draining, checks: { store, broker }, ... }
+// Readiness: whether this instance should get new connections. It turns
+// 503 as soon as Shutdown begins, or while the store or broker can't be
+// reached.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    draining := s.draining.Load()
+    checks := map[string]check{"store": runCheck(s.store.Ping)}
+    if s.broker != nil {
+        checks["broker"] = runCheck(s.broker.Ping)
+    }
+    ready := !draining
+    for _, c := range checks {
+        ready = ready && c.OK
+    }
+    status, code := "ready", http.StatusOK
+    if !ready {
+        status, code = "not ready", http.StatusServiceUnavailable
+    }
+    writeHealth(w, code, struct {
+        Status   string           `json:"status"`
+        Draining bool             `json:"draining"`
+        Checks   map[string]check `json:"checks"`
+        counts
+    }{status, draining, checks, s.counts()})
+}
+
+func writeHealth(w http.ResponseWriter, code int, body any) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(code)
+    json.NewEncoder(w).Encode(body)
+}
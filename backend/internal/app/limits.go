@@ -0,0 +1,19 @@
+package app
+
+import (
+    "time"
+
+    "slideflow/internal/ratelimit"
+)
+
+// allowPost takes a token for a post from the participant's, their IP's,
+// the room's and the server's buckets. cooldown is the room's minimum gap
+// between one participant's posts. On refusal it returns how long to wait.
+func (s *Server) allowPost(rm *room, from poster, cooldown time.Duration, now time.Time) (bool, time.Duration) {
+    return s.limiter.Allow(now,
+        ratelimit.Check{Key: "id|" + rm.ID + "|" + from.Identity, Limit: ratelimit.Every(cooldown, 1)},
+        ratelimit.Check{Key: "ip|" + from.IP, Limit: s.ipLimit},
+        ratelimit.Check{Key: "room|" + rm.ID, Limit: s.roomLimit},
+        ratelimit.Check{Key: "global", Limit: s.globalLimit},
+    )
+}
@@ -0,0 +1,277 @@
+package app
+
+import (
+    "encoding/json"
+    "io"
+    "log/slog"
+    "net/http"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "slideflow/internal/event"
+    "slideflow/internal/hub"
+    "slideflow/internal/ngword"
+    "slideflow/internal/util"
+)
+
+type postMessageReq struct {
+    Text   string `json:"text"`
+    Handle string `json:"handle"`
+    // Optional style commands, see style.go
+    Color    string `json:"color,omitempty"`
+    Size     string `json:"size,omitempty"`
+    Position string `json:"position,omitempty"`
+}
+
+// postError is a rejected post: the HTTP status and a short reason that is
+// shown to the poster as-is. RetryAfter is set when trying again later
+// will help.
+type postError struct {
+    Status     int
+    Msg        string
+    RetryAfter time.Duration
+}
+
+func (e *postError) Error() string { return e.Msg }
+
+// Outcomes of an accepted post, reported back to the poster.
+const (
+    postAccepted = "accepted"
+    postMasked   = "masked"
+    postHeld     = "held"
+)
+
+// postResult is what happened to an accepted post. Text is the comment as
+// it was (or will be) shown, i.e. after masking.
+type postResult struct {
+    Action string `json:"action"`
+    Text   string `json:"text"`
+}
+
+func (s *Server) handlePostMessage(w http.ResponseWriter, r *http.Request, rm *room, roomID string) {
+    body, err := io.ReadAll(io.LimitReader(r.Body, maxPostBodyBytes))
+    if err != nil {
+        http.Error(w, "invalid body", http.StatusBadRequest)
+        return
+    }
+    var req postMessageReq
+    if err := json.Unmarshal(body, &req); err != nil {
+        http.Error(w, "invalid json", http.StatusBadRequest)
+        return
+    }
+    res, perr := s.submitMessage(r, rm, req, false)
+    if perr != nil {
+        if perr.RetryAfter > 0 {
+            // whole seconds, rounded up so a prompt retry succeeds
+            w.Header().Set("Retry-After", strconv.Itoa(int((perr.RetryAfter+time.Second-1)/time.Second)))
+        }
+        http.Error(w, perr.Msg, perr.Status)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(map[string]any{"ok": true, "action": res.Action, "text": res.Text})
+}
+
+// submitMessage runs a post through bans, validation, the NG word filter,
+// pause, slow mode and rate limiting, then broadcasts it, masked or held
+// back for review if an NG rule or the room's review mode says so. Every way
+// of posting (HTTP, WebSocket) goes through here. r identifies the poster;
+// for a WebSocket it is the upgrade request, and socket is true.
+func (s *Server) submitMessage(r *http.Request, rm *room, req postMessageReq, socket bool) (postResult, *postError) {
+    res, perr := s.processMessage(r, rm, req)
+    s.metrics.countPost(res, perr)
+    logPost(r, rm, res, perr, socket)
+    return res, perr
+}
+
+// logPost records a comment's outcome on its request's log line and, at
+// debug level, on a line of its own, which is the only trace of comments
+// sent over a WebSocket: the upgrade request's line has been written by
+// then, so they are left off it.
+func logPost(r *http.Request, rm *room, res postResult, perr *postError, socket bool) {
+    attrs := []slog.Attr{slog.String("outcome", res.Action)}
+    if perr != nil {
+        attrs = []slog.Attr{slog.String("outcome", "rejected"), slog.String("reason", rejectReason(perr))}
+    }
+    if !socket {
+        util.LogAttrs(r.Context(), attrs...)
+    }
+    util.Logger(r.Context()).LogAttrs(r.Context(), slog.LevelDebug, "comment", append(attrs, slog.String("room", rm.ID))...)
+}
+
+func (s *Server) processMessage(r *http.Request, rm *room, req postMessageReq) (postResult, *postError) {
+    if s.draining.Load() {
+        return postResult{}, &postError{Status: http.StatusServiceUnavailable, Msg: "server shutting down", RetryAfter: time.Second}
+    }
+    req.Text = strings.TrimSpace(req.Text)
+    req.Handle = strings.TrimSpace(req.Handle)
+
+    from := s.newPoster(r, req.Handle)
+    now := time.Now()
+    rm.mu.Lock()
+    paused := rm.Paused
+    slow := rm.SlowMode
+    settings := rm.Settings
+    roomNG := rm.ng
+    ban := rm.banFor(from, now)
+    rm.mu.Unlock()
+
+    if ban != nil {
+        if ban.Until == nil {
+            return postResult{}, &postError{Status: http.StatusForbidden, Msg: "banned"}
+        }
+        return postResult{}, &postError{Status: http.StatusForbidden, Msg: "muted"}
+    }
+    if req.Text == "" {
+        return postResult{}, &postError{Status: http.StatusBadRequest, Msg: "text required"}
+    }
+    if len([]rune(req.Text)) > settings.MaxTextLen {
+        return postResult{}, &postError{Status: http.StatusBadRequest, Msg: "text too long"}
+    }
+    if req.Handle == "" && !settings.AllowAnonymous {
+        return postResult{}, &postError{Status: http.StatusBadRequest, Msg: "handle required"}
+    }
+    if len([]rune(req.Handle)) > settings.MaxHandleLen {
+        return postResult{}, &postError{Status: http.StatusBadRequest, Msg: "handle too long"}
+    }
+    style, perr := parseStyle(req, settings.AllowedStyles)
+    if perr != nil {
+        return postResult{}, perr
+    }
+
+    // NG word check
+    matches := s.ngMatches(settings, roomNG, req.Text)
+    action := ngword.Decide(matches)
+    if action == ngword.ActionReject {
+        return postResult{}, &postError{Status: http.StatusForbidden, Msg: "ng word detected"}
+    }
+
+    // Check paused and apply slow mode as cooldown
+    if paused {
+        return postResult{}, &postError{Status: http.StatusLocked, Msg: "paused"}
+    }
+
+    cooldown := time.Duration(settings.CooldownMs) * time.Millisecond
+    if slow > 0 {
+        cooldown = slow
+    }
+    if ok, wait := s.allowPost(rm, from, cooldown, now); !ok {
+        return postResult{}, &postError{Status: http.StatusTooManyRequests, Msg: "rate limited", RetryAfter: wait}
+    }
+    rm.mu.Lock()
+    rm.LastActive = now
+    rm.mu.Unlock()
+
+    ev := event.NewChat(rm.ID, req.Text, req.Handle)
+    ev.Color, ev.Size, ev.Position = style.Color, style.Size, style.Position
+    if action == ngword.ActionHold {
+        if perr := s.holdMessage(rm, ev, from, matches[0].Rule.Pattern); perr != nil {
+            return postResult{}, perr
+        }
+        return postResult{Action: postHeld, Text: ev.Text}, nil
+    }
+    if action == ngword.ActionMask {
+        ev.Text = ngword.Mask(ev.Text, matches)
+    }
+    if settings.ReviewMode {
+        if perr := s.holdMessage(rm, ev, from, reviewReason); perr != nil {
+            return postResult{}, perr
+        }
+        return postResult{Action: postHeld, Text: ev.Text}, nil
+    }
+    if perr := s.publishChat(rm, ev, from); perr != nil {
+        return postResult{}, perr
+    }
+    if action == ngword.ActionMask {
+        return postResult{Action: postMasked, Text: ev.Text}, nil
+    }
+    return postResult{Action: postAccepted, Text: ev.Text}, nil
+}
+
+// maxRecent is how many shown chats a room remembers for moderators, the
+// same window the hub keeps for replay.
+const maxRecent = hub.HistorySize
+
+// chatMeta travels with a chat to the other replicas, see hub.Frame.
+type chatMeta struct {
+    Poster poster `json:"poster"`
+}
+
+// recentMsg is a chat that was shown, with who posted it.
+type recentMsg struct {
+    Chat   *event.Chat `json:"chat"`
+    Poster poster      `json:"-"`
+}
+
+// publishChat shows ev and remembers it so a moderator can retract it or
+// ban its poster. Other replicas are told the poster along with the chat,
+// so their moderators can too. It fails with 503 when the room's hub is
+// backed up.
+func (s *Server) publishChat(rm *room, ev *event.Chat, from poster) *postError {
+    var meta []byte
+    if s.broker != nil {
+        meta, _ = json.Marshal(chatMeta{Poster: from})
+    }
+    if err := rm.publishWith(ev, meta); err != nil {
+        return &postError{Status: http.StatusServiceUnavailable, Msg: "room busy", RetryAfter: time.Second}
+    }
+    // ev is not modified after publish, so readers may encode it unlocked
+    rm.mu.Lock()
+    rm.addRecent(recentMsg{Chat: ev, Poster: from})
+    rm.mu.Unlock()
+    return nil
+}
+
+// addRecent remembers a shown chat, dropping the oldest past maxRecent.
+// Callers hold rm.mu.
+func (rm *room) addRecent(m recentMsg) {
+    if len(rm.recent) == maxRecent {
+        rm.recent = slices.Delete(rm.recent, 0, 1)
+    }
+    rm.recent = append(rm.recent, m)
+}
+
+// /rooms/{id}/messages (admin; POST without a sub is the public post)
+//
+//     GET              -> { messages: [{ chat }] }, oldest first
+//     DELETE /{msgId}  removes a shown chat from every screen
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request, rm *room, sub string) {
+    if sub == "" {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        rm.mu.Lock()
+        list := slices.Clone(rm.recent)
+        rm.mu.Unlock()
+        if list == nil {
+            list = []recentMsg{}
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        json.NewEncoder(w).Encode(map[string]any{"ok": true, "messages": list})
+        return
+    }
+
+    if r.Method != http.MethodDelete {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    rm.mu.Lock()
+    defer rm.mu.Unlock()
+    i := slices.IndexFunc(rm.recent, func(m recentMsg) bool { return m.Chat.ID == sub })
+    if i < 0 {
+        http.Error(w, "message not found", http.StatusNotFound)
+        return
+    }
+    if err := rm.Hub.Retract(sub, event.Encode(event.NewRetract(rm.ID, sub))); err != nil {
+        http.Error(w, "room busy", http.StatusServiceUnavailable)
+        return
+    }
+    rm.recent = slices.Delete(rm.recent, i, i+1)
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    json.NewEncoder(w).Encode(map[string]any{"ok": true, "id": sub})
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "net/netip"
+    "strconv"
+    "strings"
+    "time"
+
+    "slideflow/internal/metrics"
+    "slideflow/internal/util"
+)
+
+// fanoutBuckets suit hub fan-out, which should take well under a
+// millisecond unless a hub is backed up.
+var fanoutBuckets = []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1}
+
+// serverMetrics are the series /metrics exposes beyond those read from
+// the rooms at scrape time.
+type serverMetrics struct {
+    reg      *metrics.Registry
+    accepted *metrics.CounterVec
+    rejected *metrics.CounterVec
+    fanout   *metrics.Histogram
+    requests *metrics.HistogramVec
+    // token, if set, must be presented as a bearer token; otherwise only
+    // scrapes from allow are answered, since labels carry room IDs
+    token string
+    allow util.Networks
+}
+
+func (s *Server) initMetrics(token string, allow util.Networks) {
+    reg := metrics.NewRegistry()
+    m := &serverMetrics{reg: reg, token: token, allow: allow}
+    reg.GaugeFunc("slideflow_rooms_active", "Rooms loaded on this replica.", nil, func(emit func(float64, ...string)) {
+        emit(float64(len(s.rooms.all())))
+    })
+    reg.GaugeFunc("slideflow_room_clients", "Connected WebSocket and SSE clients per room.", []string{"room"}, func(emit func(float64, ...string)) {
+        for _, rm := range s.rooms.all() {
+            emit(float64(rm.Hub.ClientCount()), rm.ID)
+        }
+    })
+    m.accepted = reg.Counter("slideflow_messages_accepted_total", "Comments accepted, by what happened to them (accepted, masked, held).", "action")
+    m.rejected = reg.Counter("slideflow_messages_rejected_total", "Comments refused, by reason.", "reason")
+    m.fanout = reg.Histogram("slideflow_broadcast_fanout_seconds", "Time from a hub accepting a frame to queueing it for every client.", fanoutBuckets).With()
+    reg.CounterFunc("slideflow_hub_dropped_frames_total", "Frames discarded for slow clients, per room.", []string{"room"}, func(emit func(float64, ...string)) {
+        for _, rm := range s.rooms.all() {
+            emit(float64(rm.Hub.Counters().Dropped), rm.ID)
+        }
+    })
+    // Client IDs change on every reconnect, so labelling series by client
+    // would grow without bound; each client's count is in the room's
+    // stats instead, and this says whether any of them has lost frames.
+    reg.GaugeFunc("slideflow_hub_client_dropped_frames_max", "Most frames discarded for any one client since the room was loaded, per room. Per-client counts are in GET /rooms/{id}/stats.", []string{"room"}, func(emit func(float64, ...string)) {
+        for _, rm := range s.rooms.all() {
+            emit(float64(rm.Hub.Counters().MostDropped), rm.ID)
+        }
+    })
+    reg.CounterFunc("slideflow_hub_disconnected_clients_total", "Clients disconnected for being too slow, per room.", []string{"room"}, func(emit func(float64, ...string)) {
+        for _, rm := range s.rooms.all() {
+            emit(float64(rm.Hub.Counters().Disconnected), rm.ID)
+        }
+    })
+    reg.CounterFunc("slideflow_hub_busy_total", "Broadcasts refused because the room's hub was backed up, per room.", []string{"room"}, func(emit func(float64, ...string)) {
+        for _, rm := range s.rooms.all() {
+            emit(float64(rm.Hub.Counters().Busy), rm.ID)
+        }
+    })
+    m.requests = reg.Histogram("slideflow_http_request_duration_seconds", "HTTP requests by route pattern, method and status. WebSocket requests end at the upgrade; SSE requests last as long as the stream.", nil, "route", "method", "code")
+    s.metrics = m
+    s.hubConfig.OnFanout = func(d time.Duration) { m.fanout.Observe(d.Seconds()) }
+}
+
+// countPost records the outcome of a submitted comment.
+func (m *serverMetrics) countPost(res postResult, perr *postError) {
+    if perr != nil {
+        m.rejected.With(rejectReason(perr)).Inc()
+        return
+    }
+    m.accepted.With(res.Action).Inc()
+}
+
+// rejectReason buckets a refused comment.
+func rejectReason(perr *postError) string {
+    switch perr.Msg {
+    case "ng word detected":
+        return "ng_word"
+    case "rate limited":
+        return "rate_limited"
+    case "paused", "banned", "muted":
+        return perr.Msg
+    case "text too long", "handle too long":
+        return "too_long"
+    case "room busy", "review queue full", "review queue unavailable", "server shutting down":
+        return "unavailable"
+    }
+    return "invalid"
+}
+
+// GET /metrics -> Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if s.metrics.token != "" {
+        v := r.Header.Get("Authorization")
+        if !strings.HasPrefix(v, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(v, "Bearer ")), []byte(s.metrics.token)) != 1 {
+            http.Error(w, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+    } else if ip, _ := netip.ParseAddr(s.proxies.ClientIP(r)); !s.metrics.allow.Contains(ip) {
+        // the client, not the peer: a reverse proxy on this host would
+        // make every scrape look local
+        http.Error(w, "forbidden", http.StatusForbidden)
+        return
+    }
+    s.metrics.reg.Handler().ServeHTTP(w, r)
+}
+
+// instrument times every request for slideflow_http_request_duration_seconds.
+func (s *Server) instrument(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        rec := util.NewResponseRecorder(w)
+        next.ServeHTTP(rec, r)
+        _, route := s.mux.Handler(r)
+        if route == "" {
+            route = "unmatched"
+        }
+        method := r.Method
+        switch method {
+        case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+        default:
+            method = "other"
+        }
+        s.metrics.requests.With(route, method, strconv.Itoa(rec.Status())).Observe(time.Since(start).Seconds())
+    })
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "slices"
+
+    "slideflow/internal/ngword"
+    "slideflow/internal/store"
+    "slideflow/internal/util"
+)
+
+// maxRoomNGRules caps the size of a room's own NG list.
+const maxRoomNGRules = 500
+
+// ngMatches runs text through the NG lists selected by the room's NG mode.
+// Hits on the server-wide list take the room's NGGlobalAction.
+func (s *Server) ngMatches(st store.Settings, roomNG *ngword.List, text string) []ngword.Match {
+    var out []ngword.Match
+    if st.NGMode == "global" || st.NGMode == "both" {
+        for _, m := range s.ngGlobal.Match(text) {
+            m.Rule.Action = ngword.Action(st.NGGlobalAction)
+            out = append(out, m)
+        }
+    }
+    if st.NGMode == "room" || st.NGMode == "both" {
+        out = append(out, roomNG.Match(text)...)
+    }
+    return out
+}
+
+// setNGRules replaces the room's NG list with what edit makes of the
+// stored one, once it has been validated. Callers hold rm.mu.
+func (s *Server) setNGRules(rm *room, edit func(rules []ngword.Rule) ([]ngword.Rule, *postError)) *postError {
+    return saveError(s.updateRoom(rm, func(r *store.Room) error {
+        rules, perr := edit(slices.Clone(r.NGRules))
+        if perr != nil {
+            return perr
+        }
+        if len(rules) > maxRoomNGRules {
+            return &postError{Status: http.StatusBadRequest, Msg: "too many rules"}
+        }
+        for i := range rules {
+            if rules[i].ID == "" {
+                rules[i].ID = util.NewRoomID(8)
+            }
+        }
+        if _, err := ngword.Compile(rules); err != nil {
+            return &postError{Status: http.StatusBadRequest, Msg: err.Error()}
+        }
+        r.NGRules = rules
+        return nil
+    }))
+}
+
+// /rooms/{id}/ngwords (admin)
+//
+//     GET                 -> { rules }
+//     POST { kind, pattern, action } adds a rule
+//     PUT  { rules }      replaces the list
+//     DELETE /{ruleId}    removes a rule
+//
+// kind is "substring", "word" or "regex" and action "reject" (default),
+// "mask" or "hold"; see package ngword.
+func (s *Server) handleNGWords(w http.ResponseWriter, r *http.Request, rm *room, ruleID string) {
+    if ruleID != "" && r.Method != http.MethodDelete {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    var perr *postError
+    rm.mu.Lock()
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        var rule ngword.Rule
+        if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&rule); err != nil {
+            perr = &postError{Status: http.StatusBadRequest, Msg: "invalid json"}
+            break
+        }
+        rule.ID = ""
+        perr = s.setNGRules(rm, func(rules []ngword.Rule) ([]ngword.Rule, *postError) {
+            return append(rules, rule), nil
+        })
+    case http.MethodPut:
+        var body struct {
+            Rules []ngword.Rule `json:"rules"`
+        }
+        if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+            perr = &postError{Status: http.StatusBadRequest, Msg: "invalid json"}
+            break
+        }
+        perr = s.setNGRules(rm, func([]ngword.Rule) ([]ngword.Rule, *postError) {
+            return slices.Clone(body.Rules), nil
+        })
+    case http.MethodDelete:
+        perr = s.setNGRules(rm, func(rules []ngword.Rule) ([]ngword.Rule, *postError) {
+            i := slices.IndexFunc(rules, func(x ngword.Rule) bool { return x.ID == ruleID })
+            if ruleID == "" || i < 0 {
+                return nil, &postError{Status: http.StatusNotFound, Msg: "rule not found"}
+            }
+            return slices.Delete(rules, i, i+1), nil
+        })
+    default:
+        perr = &postError{Status: http.StatusMethodNotAllowed, Msg: "method not allowed"}
+    }
+    rules := rm.NGRules
+    rm.mu.Unlock()
+
+    if perr != nil {
+        http.Error(w, perr.Msg, perr.Status)
+        return
+    }
+    if rules == nil {
+        rules = []ngword.Rule{}
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    json.NewEncoder(w).Encode(map[string]any{"ok": true, "rules": rules})
+}
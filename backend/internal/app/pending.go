@@ -0,0 +1,208 @@
+package app
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "log/slog"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+
+    "slideflow/internal/event"
+    "slideflow/internal/store"
+)
+
+// maxPending caps a room's review queue; posts beyond it are refused.
+const maxPending = 200
+
+// reviewReason is the hold reason for comments queued by review mode rather
+// than by an NG rule.
+const reviewReason = "review mode"
+
+// pendingMsg is a comment held back for a moderator.
+type pendingMsg struct {
+    Chat       *event.Chat `json:"chat"`
+    Poster     poster      `json:"-"`
+    Reason     string      `json:"reason"`
+    ReceivedAt time.Time   `json:"receivedAt"`
+}
+
+// heldMsg is a pendingMsg as kept in the store. Unlike the list sent to
+// moderators it records the poster, so whichever replica approves the
+// comment or bans its author knows who sent it.
+type heldMsg struct {
+    pendingMsg
+    From poster `json:"from"`
+}
+
+// holdMessage queues ev for review instead of broadcasting it and shows it
+// to connected moderators. reason says why, e.g. the NG rule that matched.
+// The queue is in the store, shared by every replica.
+func (s *Server) holdMessage(rm *room, ev *event.Chat, from poster, reason string) *postError {
+    held, err := s.store.Held(rm.ID)
+    if err != nil {
+        slog.Error("review queue read failed", "room", rm.ID, "err", err)
+        return &postError{Status: http.StatusServiceUnavailable, Msg: "review queue unavailable", RetryAfter: time.Second}
+    }
+    // Replicas holding at the same moment may overshoot a little.
+    if len(held) >= maxPending {
+        return &postError{Status: http.StatusServiceUnavailable, Msg: "review queue full"}
+    }
+    p := &pendingMsg{Chat: ev, Poster: from, Reason: reason, ReceivedAt: time.Now()}
+    if err := s.hold(rm, p); err != nil {
+        slog.Error("review queue write failed", "room", rm.ID, "err", err)
+        return &postError{Status: http.StatusServiceUnavailable, Msg: "review queue unavailable", RetryAfter: time.Second}
+    }
+    rm.Hub.BroadcastAdmin(event.Encode(event.NewPending(rm.ID, ev, reason)))
+    return nil
+}
+
+// hold adds p to the room's review queue.
+func (s *Server) hold(rm *room, p *pendingMsg) error {
+    b, err := json.Marshal(heldMsg{pendingMsg: *p, From: p.Poster})
+    if err != nil {
+        return err
+    }
+    return s.store.Hold(rm.ID, p.Chat.ID, b)
+}
+
+// listPending returns the room's review queue, oldest first.
+func (s *Server) listPending(rm *room) ([]*pendingMsg, error) {
+    held, err := s.store.Held(rm.ID)
+    if err != nil {
+        return nil, err
+    }
+    list := make([]*pendingMsg, 0, len(held))
+    for _, b := range held {
+        p, err := decodeHeld(b)
+        if err != nil {
+            slog.Warn("review queue entry unreadable", "room", rm.ID, "err", err)
+            continue
+        }
+        list = append(list, p)
+    }
+    slices.SortFunc(list, func(a, b *pendingMsg) int { return a.ReceivedAt.Compare(b.ReceivedAt) })
+    return list, nil
+}
+
+// takePending removes the held message with the given chat ID. It fails
+// with store.ErrNotFound if there is none, or if another moderator, on
+// this replica or another, got to it first.
+func (s *Server) takePending(rm *room, id string) (*pendingMsg, error) {
+    b, err := s.store.Release(rm.ID, id)
+    if err != nil {
+        return nil, err
+    }
+    return decodeHeld(b)
+}
+
+func decodeHeld(b []byte) (*pendingMsg, error) {
+    var h heldMsg
+    if err := json.Unmarshal(b, &h); err != nil {
+        return nil, err
+    }
+    if h.Chat == nil {
+        return nil, errors.New("entry without a chat")
+    }
+    h.Poster = h.From
+    return &h.pendingMsg, nil
+}
+
+// /rooms/{id}/pending (admin)
+//
+//     GET                    -> { pending: [{ chat, reason, receivedAt }] }
+//     POST /{msgId}/approve  broadcasts the message; an optional body
+//                            { text } replaces its text first
+//     POST /{msgId}/reject   drops it
+//
+// Either decision is announced to admin sockets as an event.Review.
+func (s *Server) handlePending(w http.ResponseWriter, r *http.Request, rm *room, sub string) {
+    if sub == "" {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        list, err := s.listPending(rm)
+        if err != nil {
+            slog.Error("review queue read failed", "room", rm.ID, "err", err)
+            http.Error(w, "failed to load review queue", http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        json.NewEncoder(w).Encode(map[string]any{"ok": true, "pending": list})
+        return
+    }
+
+    id, verb, _ := strings.Cut(sub, "/")
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if verb != "approve" && verb != "reject" {
+        http.NotFound(w, r)
+        return
+    }
+    var edit struct {
+        Text *string `json:"text"`
+    }
+    if verb == "approve" {
+        body, err := io.ReadAll(io.LimitReader(r.Body, maxPostBodyBytes))
+        if err != nil {
+            http.Error(w, "invalid body", http.StatusBadRequest)
+            return
+        }
+        if len(body) > 0 {
+            if err := json.Unmarshal(body, &edit); err != nil {
+                http.Error(w, "invalid json", http.StatusBadRequest)
+                return
+            }
+        }
+    }
+    if edit.Text != nil {
+        // Moderators are trusted with the wording but not with the limits.
+        *edit.Text = strings.TrimSpace(*edit.Text)
+        rm.mu.Lock()
+        maxLen := rm.Settings.MaxTextLen
+        rm.mu.Unlock()
+        if *edit.Text == "" {
+            http.Error(w, "text required", http.StatusBadRequest)
+            return
+        }
+        if len([]rune(*edit.Text)) > maxLen {
+            http.Error(w, "text too long", http.StatusBadRequest)
+            return
+        }
+    }
+    p, err := s.takePending(rm, id)
+    if errors.Is(err, store.ErrNotFound) {
+        http.Error(w, "message not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        slog.Error("review queue release failed", "room", rm.ID, "msg", id, "err", err)
+        http.Error(w, "failed to load message", http.StatusInternalServerError)
+        return
+    }
+    review := event.NewReview(rm.ID, id, verb == "approve")
+    if review.Approved {
+        // Publish a copy; a concurrent GET may still be encoding p.Chat.
+        ev := *p.Chat
+        if edit.Text != nil {
+            ev.Text = *edit.Text
+        }
+        if perr := s.publishChat(rm, &ev, p.Poster); perr != nil {
+            // Back in the queue, still at its place, for another try.
+            if err := s.hold(rm, p); err != nil {
+                slog.Error("review queue write failed", "room", rm.ID, "msg", id, "err", err)
+            }
+            http.Error(w, perr.Msg, perr.Status)
+            return
+        }
+        review.Text = ev.Text
+    }
+    rm.Hub.BroadcastAdmin(event.Encode(review))
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    json.NewEncoder(w).Encode(map[string]any{"ok": true, "id": id, "approved": review.Approved, "text": review.Text})
+}
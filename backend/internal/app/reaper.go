@@ -0,0 +1,89 @@
+package app
+
+import (
+    "log/slog"
+    "time"
+
+    "slideflow/internal/hub"
+)
+
+const reapInterval = time.Minute
+
+// closeRoom removes the room from the registry and the store and
+// disconnects its clients with reason, here and on the other replicas. Its
+// rate-limit buckets are left to eviction.
+func (s *Server) closeRoom(id, reason string) {
+    // Delete from the store under the shard lock so lookupRoom can't
+    // reload it.
+    sh := s.rooms.shard(id)
+    sh.mu.Lock()
+    rm := sh.rooms[id]
+    delete(sh.rooms, id)
+    if err := s.store.Delete(id); err != nil {
+        slog.Error("store delete failed", "room", id, "err", err)
+    }
+    sh.mu.Unlock()
+    if rm != nil {
+        s.unfollow(rm)
+        rm.Hub.Stop(hub.CloseRoomClosed, reason)
+    }
+    if s.broker != nil {
+        s.notify(id, "close", reason)
+        if err := s.broker.Del(seqKey(id)); err != nil {
+            slog.Error("broker del failed", "room", id, "key", seqKey(id), "err", err)
+        }
+    }
+    slog.Info("room closed", "room", id, "reason", reason)
+}
+
+func (s *Server) reapLoop() {
+    t := time.NewTicker(reapInterval)
+    defer t.Stop()
+    for now := range t.C {
+        s.reap(now)
+    }
+}
+
+// reap closes rooms past their TTL and unloads rooms that have had neither
+// clients nor posts for the idle timeout; those stay in the store, so
+// their links and QR codes keep working and the next visit loads them
+// again. Rooms left without local clients stop following the broker. It
+// also evicts rate-limit buckets that have refilled.
+func (s *Server) reap(now time.Time) {
+    var expired []string
+    var idle []string
+
+    if s.roomTTL > 0 {
+        recs, err := s.store.List()
+        if err != nil {
+            slog.Error("store list failed", "err", err)
+        }
+        for _, rec := range recs {
+            if now.Sub(rec.CreatedAt) > s.roomTTL {
+                expired = append(expired, rec.ID)
+            }
+        }
+    }
+
+    for _, rm := range s.rooms.all() {
+        rm.mu.Lock()
+        lastActive := rm.LastActive
+        rm.mu.Unlock()
+        if rm.Hub.ClientCount() > 0 {
+            continue
+        }
+        if s.idleTimeout > 0 && now.Sub(lastActive) > s.idleTimeout {
+            idle = append(idle, rm.ID)
+        } else {
+            s.unfollow(rm)
+        }
+    }
+    s.limiter.Evict(now)
+
+    for _, id := range expired {
+        s.closeRoom(id, "room expired")
+    }
+    for _, id := range idle {
+        s.unloadIdle(id)
+    }
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+    "hash/fnv"
+    "sync"
+)
+
+// registryShards is how many locks the room registry is spread over, so
+// lookups in one room don't wait on another room being loaded or closed.
+const registryShards = 32
+
+// registry maps room IDs to live rooms.
+type registry struct {
+    shards [registryShards]registryShard
+}
+
+type registryShard struct {
+    mu    sync.Mutex
+    rooms map[string]*room
+}
+
+func newRegistry() *registry {
+    g := &registry{}
+    for i := range g.shards {
+        g.shards[i].rooms = make(map[string]*room)
+    }
+    return g
+}
+
+// shard returns the shard owning id. Loading, creating and closing a room
+// all happen under its lock, so they can't interleave for the same ID.
+func (g *registry) shard(id string) *registryShard {
+    h := fnv.New32a()
+    h.Write([]byte(id))
+    return &g.shards[h.Sum32()%registryShards]
+}
+
+// all returns a snapshot of every live room.
+func (g *registry) all() []*room {
+    var out []*room
+    for i := range g.shards {
+        sh := &g.shards[i]
+        sh.mu.Lock()
+        for _, rm := range sh.rooms {
+            out = append(out, rm)
+        }
+        sh.mu.Unlock()
+    }
+    return out
+}
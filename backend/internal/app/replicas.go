@@ -0,0 +1,236 @@
+package app
+
+import (
+    "encoding/json"
+    "log/slog"
+    "slices"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/websocket"
+
+    "slideflow/internal/event"
+    "slideflow/internal/hub"
+    "slideflow/internal/util"
+)
+
+// Replicas share rooms through s.broker. Every frame a room's hub accepts
+// from a local publisher is sent on the room's channel; replicas with
+// clients in that room subscribe and feed the frames to their own hub.
+// Changes to a room's stored state are announced on controlChannel so the
+// other replicas reload it.
+
+// controlChannel carries room updates and closes between replicas.
+const controlChannel = "slideflow:control"
+
+// outboxSize bounds the messages waiting to go to the broker.
+const outboxSize = 1024
+
+func roomChannel(id string) string { return "slideflow:room:" + id }
+
+func seqKey(id string) string { return "slideflow:seq:" + id }
+
+// relayMsg is a hub frame on a room channel.
+type relayMsg struct {
+    Origin string    `json:"origin"`
+    Frame  hub.Frame `json:"frame"`
+}
+
+// controlMsg tells the other replicas a room changed. Op is "update" (the
+// stored room changed) or "close".
+type controlMsg struct {
+    Origin string `json:"origin"`
+    Room   string `json:"room"`
+    Op     string `json:"op"`
+    Reason string `json:"reason,omitempty"`
+}
+
+type outMsg struct {
+    channel string
+    data    []byte
+}
+
+// roomPeers is a room hub's link to the other replicas.
+type roomPeers struct {
+    s  *Server
+    id string
+}
+
+func (p roomPeers) Forward(f hub.Frame) {
+    b, _ := json.Marshal(relayMsg{Origin: p.s.node, Frame: f})
+    p.s.send(roomChannel(p.id), b)
+}
+
+func (p roomPeers) NextSeq() (uint64, error) {
+    return p.s.broker.Incr(seqKey(p.id))
+}
+
+// newHub starts the hub for room id, created at created. Standalone, its
+// seqs start over with every hub, so each gets a new epoch. With a broker
+// they come from the room's counter there, which lasts as long as the
+// room, so the epoch is derived from the room and every replica agrees.
+func (s *Server) newHub(id string, created time.Time) *hub.Hub {
+    cfg := s.hubConfig
+    cfg.Epoch = util.NewRoomID(8)
+    if s.broker != nil {
+        cfg.Peers = roomPeers{s: s, id: id}
+        cfg.Epoch = strconv.FormatInt(created.UnixNano(), 36)
+    }
+    h := hub.NewHub(cfg)
+    go h.Run()
+    return h
+}
+
+// send queues a message for the broker. Publishing happens on one
+// goroutine so a room's frames leave in the order its hub took them, and
+// so a slow broker never holds up a post; if it falls too far behind,
+// messages are dropped.
+func (s *Server) send(channel string, data []byte) {
+    select {
+    case s.outbox <- outMsg{channel: channel, data: data}:
+    default:
+        slog.Warn("broker outbox full, message dropped", "channel", channel)
+    }
+}
+
+func (s *Server) runOutbox() {
+    for m := range s.outbox {
+        if err := s.broker.Publish(m.channel, m.data); err != nil {
+            slog.Error("broker publish failed", "channel", m.channel, "err", err)
+        }
+    }
+}
+
+// notify tells the other replicas that room id changed.
+func (s *Server) notify(id, op, reason string) {
+    if s.broker == nil {
+        return
+    }
+    b, _ := json.Marshal(controlMsg{Origin: s.node, Room: id, Op: op, Reason: reason})
+    s.send(controlChannel, b)
+}
+
+// follow subscribes to the room's channel if it isn't already. It is
+// called whenever a client connects.
+func (s *Server) follow(rm *room) {
+    if s.broker == nil {
+        return
+    }
+    rm.followMu.Lock()
+    defer rm.followMu.Unlock()
+    if rm.unfollow != nil {
+        return
+    }
+    cancel, err := s.broker.Subscribe(roomChannel(rm.ID), func(b []byte) {
+        var msg relayMsg
+        if err := json.Unmarshal(b, &msg); err != nil || msg.Origin == s.node {
+            return
+        }
+        // A busy hub counts the refusal in its stats.
+        if rm.Hub.Receive(msg.Frame) == nil {
+            rm.mirror(msg.Frame)
+        }
+    })
+    if err != nil {
+        slog.Error("broker subscribe failed", "room", rm.ID, "err", err)
+        return
+    }
+    rm.unfollow = cancel
+}
+
+// mirror keeps the room's recent list in step with chats shown and
+// retracted by other replicas, so moderators here can retract them and
+// ban their posters too.
+func (rm *room) mirror(f hub.Frame) {
+    switch {
+    case f.Retract != "":
+        rm.mu.Lock()
+        rm.recent = slices.DeleteFunc(rm.recent, func(m recentMsg) bool { return m.Chat.ID == f.Retract })
+        rm.mu.Unlock()
+    case f.Seq > 0:
+        var ev event.Chat
+        if err := json.Unmarshal(f.Data, &ev); err != nil || ev.Type != event.TypeChat {
+            return
+        }
+        var meta chatMeta
+        if len(f.Meta) > 0 {
+            if err := json.Unmarshal(f.Meta, &meta); err != nil {
+                slog.Warn("relayed chat meta unreadable", "room", rm.ID, "err", err)
+            }
+        }
+        rm.mu.Lock()
+        rm.addRecent(recentMsg{Chat: &ev, Poster: meta.Poster})
+        rm.mu.Unlock()
+    }
+}
+
+// unfollow drops the room's subscription, if any.
+func (s *Server) unfollow(rm *room) {
+    rm.followMu.Lock()
+    cancel := rm.unfollow
+    rm.unfollow = nil
+    rm.followMu.Unlock()
+    if cancel != nil {
+        cancel()
+    }
+}
+
+// handleControl applies another replica's change to our copy of the room.
+// Rooms we haven't loaded are left alone; they are read fresh from the
+// store when needed.
+func (s *Server) handleControl(b []byte) {
+    var msg controlMsg
+    if err := json.Unmarshal(b, &msg); err != nil || msg.Origin == s.node {
+        return
+    }
+    switch msg.Op {
+    case "update":
+        s.reloadRoom(msg.Room)
+    case "close":
+        s.dropRoom(msg.Room, hub.CloseRoomClosed, msg.Reason)
+    }
+}
+
+// reloadRoom refreshes a loaded room's stored state. The replica that made
+// the change has already sent the matching events to every client.
+func (s *Server) reloadRoom(id string) {
+    sh := s.rooms.shard(id)
+    sh.mu.Lock()
+    rm := sh.rooms[id]
+    sh.mu.Unlock()
+    if rm == nil {
+        return
+    }
+    rec, err := s.store.Get(id)
+    if err != nil {
+        slog.Error("room reload failed", "room", id, "err", err)
+        return
+    }
+    rm.mu.Lock()
+    rm.load(rec)
+    rm.mu.Unlock()
+}
+
+// dropRoom forgets our copy of a room without touching the store, and
+// disconnects its clients with code and reason.
+func (s *Server) dropRoom(id string, code int, reason string) {
+    sh := s.rooms.shard(id)
+    sh.mu.Lock()
+    rm := sh.rooms[id]
+    delete(sh.rooms, id)
+    sh.mu.Unlock()
+    if rm == nil {
+        return
+    }
+    s.unfollow(rm)
+    rm.Hub.Stop(code, reason)
+}
+
+// unloadIdle drops an idle room from this replica's memory only. Rooms
+// are closed for good only by ROOM_TTL or their presenter: an idle room
+// may be a talk paused over lunch, and with several replicas one of them
+// can't tell whether the room is idle elsewhere. Clients that race the
+// unload get a going-away close and reconnect.
+func (s *Server) unloadIdle(id string) {
+    s.dropRoom(id, websocket.CloseGoingAway, "room unloaded")
+}
@@ -1,71 +1,338 @@
 package app
 
 import (
+    "bytes"
     "encoding/base64"
     "encoding/json"
+    "errors"
+    "fmt"
     "io"
+    "log/slog"
     "net/http"
-    "os"
     "strings"
     "sync"
+    "sync/atomic"
     "time"
 
     qrcode "github.com/skip2/go-qrcode"
 
+    "slideflow/internal/broker"
+    "slideflow/internal/config"
+    "slideflow/internal/event"
     "slideflow/internal/hub"
+    "slideflow/internal/ngword"
+    "slideflow/internal/ratelimit"
+    "slideflow/internal/store"
     "slideflow/internal/util"
 )
 
+// room is a live room. ID, AdminToken, Hub and CreatedAt never change; mu
+// guards everything else.
 type room struct {
-    ID       string
-    Hub      *hub.Hub
-    Paused   bool
-    SlowMode time.Duration
+    ID         string
+    AdminToken string
+    Hub        *hub.Hub
+    CreatedAt  time.Time
+
+    mu         sync.Mutex
+    Paused     bool
+    SlowMode   time.Duration
+    Settings   store.Settings
+    // NGRules is the room's own NG list and ng its compiled form
+    NGRules    []ngword.Rule
+    ng         *ngword.List
+    // Bans are the room's bans and mutes; expired mutes linger until the
+    // list is next changed
+    Bans       []store.Ban
+    // recent holds the last chats shown, for moderators (in memory only)
+    recent     []recentMsg
+    // LastActive is bumped on posts and WebSocket connects
+    LastActive time.Time
+
+    // followMu guards unfollow, which cancels the room's broker
+    // subscription while this replica has clients (see replicas.go)
+    followMu sync.Mutex
+    unfollow func()
+}
+
+// record returns the persisted form of the room. Callers hold rm.mu.
+func (rm *room) record() store.Room {
+    return store.Room{
+        ID:         rm.ID,
+        AdminToken: rm.AdminToken,
+        Paused:     rm.Paused,
+        SlowModeMs: int64(rm.SlowMode / time.Millisecond),
+        Settings:   rm.Settings,
+        NGRules:    rm.NGRules,
+        Bans:       rm.Bans,
+        CreatedAt:  rm.CreatedAt,
+    }
+}
+
+// publish sends ev to every client of the room. Chat events are sequenced
+// and kept for replay; everything else is live-only. It fails with
+// hub.ErrBusy rather than wait for a backed-up hub.
+func (rm *room) publish(ev event.Event) error {
+    return rm.publishWith(ev, nil)
+}
+
+// publishWith is publish with meta for the other replicas' copies of a
+// chat, see hub.Frame.
+func (rm *room) publishWith(ev event.Event, meta []byte) error {
+    h := ev.EventHeader()
+    switch h.Type {
+    case event.TypeChat:
+        return rm.Hub.Publish(h.ID, meta, func(seq uint64) []byte {
+            h.Seq, h.Epoch = seq, rm.Hub.Epoch()
+            return event.Encode(ev)
+        })
+    case event.TypeConfig, event.TypePause:
+        // only the latest of these matters to a client that's behind
+        return rm.Hub.BroadcastLatest(string(h.Type), event.Encode(ev))
+    }
+    return rm.Hub.Broadcast(event.Encode(ev))
+}
+
+// announce publishes a state change that has already been saved. There is
+// nothing to undo if the hub is too busy to take it, so that is only
+// logged; clients get the room's settings again when they reconnect.
+func (rm *room) announce(ev event.Event) {
+    if err := rm.publish(ev); err != nil {
+        slog.Warn("event not sent", "room", rm.ID, "type", ev.EventHeader().Type, "err", err)
+    }
 }
 
 type Server struct {
     mux   *http.ServeMux
-    rooms map[string]*room
-    mu    sync.Mutex
-    // rate[roomID][identity] = lastPostTime
-    rate map[string]map[string]time.Time
-    // NG words (lowercased)
-    ngWords []string
+    store store.RoomStore
+    // rooms caches live rooms (with running hubs) loaded from store
+    rooms *registry
+    // limiter holds the post rate limits' buckets, see limits.go
+    limiter     *ratelimit.Limiter
+    ipLimit     ratelimit.Limit
+    roomLimit   ratelimit.Limit
+    globalLimit ratelimit.Limit
+    // ngGlobal is the server-wide NG list
+    ngGlobal *ngword.List
+    // roomTTL closes rooms this long after creation, idleTimeout closes
+    // rooms without clients or posts; zero disables either
+    roomTTL     time.Duration
+    idleTimeout time.Duration
+    // proxies are the reverse proxies whose forwarding headers we believe
+    proxies *util.Proxies
+    // readTimeout and writeTimeout bound requests other than streams,
+    // see timeouts.go
+    readTimeout  time.Duration
+    writeTimeout time.Duration
+    // roomIDLength, qrSize and qrLevel shape new rooms' IDs and QR codes
+    roomIDLength int
+    qrSize       int
+    qrLevel      qrcode.RecoveryLevel
+    // hubConfig sets every room hub's backpressure policy
+    hubConfig hub.Config
+    // broker links this replica to the others; nil runs standalone. node
+    // tells our own messages apart and outbox queues them for the broker.
+    broker broker.Broker
+    node   string
+    outbox chan outMsg
+    // draining is set by Shutdown
+    draining atomic.Bool
+    // metrics backs /metrics, see metrics.go
+    metrics *serverMetrics
+    // started is when NewServer ran, for uptime in /healthz
+    started time.Time
 }
 
-func NewServer() *Server {
+// NewServer serves rooms from st, as configured by cfg. With a broker,
+// several servers sharing the same store and broker act as one; st must
+// then be shared too.
+func NewServer(cfg *config.Config, st store.RoomStore, b broker.Broker) (*Server, error) {
     s := &Server{
-        mux:   http.NewServeMux(),
-        rooms: make(map[string]*room),
-        rate:  make(map[string]map[string]time.Time),
+        mux:     http.NewServeMux(),
+        store:   st,
+        rooms:   newRegistry(),
+        broker:  b,
+        started: time.Now(),
+
+        limiter:      ratelimit.New(),
+        ipLimit:      cfg.RateLimitIP,
+        roomLimit:    cfg.RateLimitRoom,
+        globalLimit:  cfg.RateLimitGlobal,
+        roomTTL:      cfg.RoomTTL,
+        idleTimeout:  cfg.RoomIdleTimeout,
+        readTimeout:  cfg.ReadTimeout,
+        writeTimeout: cfg.WriteTimeout,
+        roomIDLength: cfg.RoomIDLength,
+        qrSize:       cfg.QRSize,
+        qrLevel:      qrLevels[cfg.QRLevel],
+        hubConfig:    hub.Config{Policy: cfg.HubBackpressure, SendBuffer: cfg.HubSendBuffer},
     }
 
     // Routes
     s.mux.HandleFunc("/health", s.handleHealth)
+    s.mux.HandleFunc("/healthz", s.handleHealthz)
+    s.mux.HandleFunc("/readyz", s.handleReadyz)
     s.mux.HandleFunc("/rooms", s.handleCreateRoom)
     s.mux.HandleFunc("/ws/", s.handleWS)
+    s.mux.HandleFunc("/events/", s.handleEvents)
     s.mux.HandleFunc("/overlay/", s.handleOverlay)
     s.mux.HandleFunc("/post/", s.handlePostForm)
     s.mux.HandleFunc("/admin/", s.handleAdmin)
     s.mux.HandleFunc("/rooms/", s.handleRoomSubroutes)
     s.mux.HandleFunc("/present", s.handlePresent)
+    s.mux.HandleFunc("/schema/events.json", s.handleEventSchema)
+    s.mux.HandleFunc("/metrics", s.handleMetrics)
+
+    var rules []ngword.Rule
+    for _, w := range cfg.NGWords {
+        rules = append(rules, ngword.Rule{Kind: ngword.KindSubstring, Pattern: w})
+    }
+    ng, err := ngword.Compile(rules)
+    if err != nil {
+        return nil, fmt.Errorf("ng words: %w", err)
+    }
+    s.ngGlobal = ng
+
+    s.proxies, err = util.ParseProxies(strings.Join(cfg.TrustedProxies, ","), cfg.ProxyHeader)
+    if err != nil {
+        return nil, err
+    }
+
+    metricsAllow, err := util.ParseNetworks(strings.Join(cfg.MetricsAllow, ","))
+    if err != nil {
+        return nil, fmt.Errorf("metrics allow: %w", err)
+    }
+    // after hubConfig, which it hooks into
+    s.initMetrics(cfg.MetricsToken, metricsAllow)
+
+    if s.broker != nil {
+        s.node, err = util.NewToken(8)
+        if err != nil {
+            return nil, fmt.Errorf("node id: %w", err)
+        }
+        s.outbox = make(chan outMsg, outboxSize)
+        go s.runOutbox()
+        if _, err := s.broker.Subscribe(controlChannel, s.handleControl); err != nil {
+            return nil, fmt.Errorf("broker: %w", err)
+        }
+    }
+
+    go s.reapLoop()
+    return s, nil
+}
+
+// qrLevels maps config.Config.QRLevel to the QR error correction level.
+var qrLevels = map[string]qrcode.RecoveryLevel{
+    "low":     qrcode.Low,
+    "medium":  qrcode.Medium,
+    "high":    qrcode.High,
+    "highest": qrcode.Highest,
+}
 
-    // Load NG words from env (comma-separated), fallback to a small default
-    if v := strings.TrimSpace(os.Getenv("NG_WORDS")); v != "" {
-        parts := strings.Split(v, ",")
-        for _, p := range parts {
-            p = strings.ToLower(strings.TrimSpace(p))
-            if p != "" {
-                s.ngWords = append(s.ngWords, p)
-            }
+func (s *Server) Handler() http.Handler { return s.instrument(s.timeouts(s.mux)) }
+
+// lookupRoom returns the live room, loading it from the store (and starting
+// its hub) if this process hasn't seen it since startup.
+func (s *Server) lookupRoom(id string) (*room, bool) {
+    sh := s.rooms.shard(id)
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+    if rm, ok := sh.rooms[id]; ok {
+        return rm, true
+    }
+    rec, err := s.store.Get(id)
+    if err != nil {
+        if !errors.Is(err, store.ErrNotFound) {
+            slog.Error("store get failed", "room", id, "err", err)
+        }
+        return nil, false
+    }
+    if rec.Settings.MaxTextLen == 0 {
+        // stored before per-room settings existed
+        rec.Settings = defaultSettings()
+    }
+    ng, err := ngword.Compile(rec.NGRules)
+    if err != nil {
+        slog.Warn("room NG rules invalid", "room", id, "err", err)
+    }
+    rm := &room{
+        ID:         rec.ID,
+        AdminToken: rec.AdminToken,
+        Hub:        s.newHub(rec.ID, rec.CreatedAt),
+        Paused:     rec.Paused,
+        SlowMode:   time.Duration(rec.SlowModeMs) * time.Millisecond,
+        Settings:   rec.Settings,
+        NGRules:    rec.NGRules,
+        ng:         ng,
+        Bans:       rec.Bans,
+        CreatedAt:  rec.CreatedAt,
+        LastActive: time.Now(),
+    }
+    sh.rooms[id] = rm
+    return rm, true
+}
+
+// updateRoom makes a change to the room's stored state and tells the other
+// replicas to reload it. change is applied to the latest copy in the
+// store, not ours, so that what another replica saved meanwhile is kept,
+// and it may run more than once; the result becomes our copy. Errors from
+// change come back as is. Callers hold rm.mu.
+func (s *Server) updateRoom(rm *room, change func(r *store.Room) error) error {
+    rec, err := s.store.Update(rm.ID, func(r *store.Room) error {
+        if r.Settings.MaxTextLen == 0 {
+            // stored before per-room settings existed
+            r.Settings = defaultSettings()
+        }
+        return change(r)
+    })
+    if err != nil {
+        var perr *postError
+        if !errors.As(err, &perr) {
+            slog.Error("store update failed", "room", rm.ID, "err", err)
         }
-    } else {
-        s.ngWords = []string{"死ね", "fuck", "shit"}
+        return err
+    }
+    rm.load(rec)
+    s.notify(rm.ID, "update", "")
+    return nil
+}
+
+// saveError is the response to an error from updateRoom: change's own
+// *postError, or a 500.
+func saveError(err error) *postError {
+    var perr *postError
+    if err == nil || errors.As(err, &perr) {
+        return perr
     }
-    return s
+    return &postError{Status: http.StatusInternalServerError, Msg: "failed to save room"}
 }
 
-func (s *Server) Handler() http.Handler { return s.mux }
+// load takes rec as the room's stored state. Callers hold rm.mu.
+func (rm *room) load(rec store.Room) {
+    ng, err := ngword.Compile(rec.NGRules)
+    if err != nil {
+        slog.Warn("room NG rules invalid", "room", rm.ID, "err", err)
+    }
+    rm.Paused = rec.Paused
+    rm.SlowMode = time.Duration(rec.SlowModeMs) * time.Millisecond
+    if rec.Settings.MaxTextLen != 0 {
+        rm.Settings = rec.Settings
+    }
+    rm.NGRules = rec.NGRules
+    rm.ng = ng
+    rm.Bans = rec.Bans
+}
+
+// saveRoom persists a new room and tells the other replicas about it.
+// Changes to an existing room go through updateRoom. Callers hold rm.mu.
+func (s *Server) saveRoom(rm *room) error {
+    if err := s.store.Put(rm.record()); err != nil {
+        slog.Error("store put failed", "room", rm.ID, "err", err)
+        return err
+    }
+    s.notify(rm.ID, "update", "")
+    return nil
+}
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -73,26 +340,73 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
     _, _ = w.Write([]byte("ok"))
 }
 
-// POST /rooms -> { roomId, overlayUrl, postUrl, qrPngBase64 }
+// GET /schema/events.json -> JSON Schema for every event on /ws/:roomId
+func (s *Server) handleEventSchema(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    w.Header().Set("Content-Type", "application/schema+json")
+    _, _ = w.Write(event.Schema)
+}
+
+// POST /rooms [{ settings }] -> { roomId, adminToken, overlayUrl, postUrl, adminUrl, qrPngBase64 }
+// The admin token is only ever returned here, to the room's creator. The
+// body is optional; settings not given take their defaults.
 func (s *Server) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodPost {
         http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
         return
     }
+    if s.refuseDraining(w) {
+        return
+    }
+    body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+    if err != nil {
+        http.Error(w, "invalid body", http.StatusBadRequest)
+        return
+    }
+    var req struct {
+        Settings settingsPatch `json:"settings"`
+    }
+    if len(bytes.TrimSpace(body)) > 0 {
+        if err := json.Unmarshal(body, &req); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+    }
+    settings, err := req.Settings.apply(defaultSettings())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
-    id := util.NewRoomID(10)
-    h := hub.NewHub()
-    go h.Run()
-    s.mu.Lock()
-    s.rooms[id] = &room{ID: id, Hub: h}
-    s.mu.Unlock()
+    token, err := util.NewToken(24)
+    if err != nil {
+        http.Error(w, "failed to create room", http.StatusInternalServerError)
+        return
+    }
+    id := util.NewRoomID(s.roomIDLength)
+    now := time.Now()
+    rm := &room{ID: id, AdminToken: token, Settings: settings, CreatedAt: now, LastActive: now}
+    sh := s.rooms.shard(id)
+    sh.mu.Lock()
+    if err := s.saveRoom(rm); err != nil {
+        sh.mu.Unlock()
+        http.Error(w, "failed to create room", http.StatusInternalServerError)
+        return
+    }
+    rm.Hub = s.newHub(id, now)
+    sh.rooms[id] = rm
+    sh.mu.Unlock()
 
-    base := util.BaseURL(r)
+    base := s.proxies.BaseURL(r)
     overlayURL := base + "/overlay/" + id
     postURL := base + "/post/" + id
+    adminURL := base + "/admin/" + id + "?token=" + token
 
     // Generate QR for post URL
-    png, err := qrcode.Encode(postURL, qrcode.Medium, 256)
+    png, err := qrcode.Encode(postURL, s.qrLevel, s.qrSize)
     if err != nil {
         http.Error(w, "failed to generate QR", http.StatusInternalServerError)
         return
@@ -101,56 +415,76 @@ func (s *Server) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
 
     resp := map[string]string{
         "roomId":      id,
+        "adminToken":  token,
         "overlayUrl":  overlayURL,
         "postUrl":     postURL,
+        "adminUrl":    adminURL,
         "qrPngBase64": qrB64,
     }
 
+    // The creator is the room's first admin; let their browser in directly.
+    s.setAdminCookie(w, r, rm)
     w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
     json.NewEncoder(w).Encode(resp)
 }
 
 // --- Room subroutes ---
 func (s *Server) handleRoomSubroutes(w http.ResponseWriter, r *http.Request) {
-    // Expect /rooms/{id}/...
+    // Expect /rooms/{id} or /rooms/{id}/...
     rest := strings.TrimPrefix(r.URL.Path, "/rooms/")
     parts := strings.Split(rest, "/")
-    if len(parts) < 2 {
-        http.NotFound(w, r)
-        return
+    roomID, tail, sub := parts[0], "", ""
+    if len(parts) > 1 {
+        tail = parts[1]
+    }
+    if len(parts) > 2 {
+        sub = strings.Join(parts[2:], "/")
     }
-    roomID, tail := parts[0], parts[1]
-    s.mu.Lock()
-    rm, ok := s.rooms[roomID]
-    s.mu.Unlock()
+    util.LogAttrs(r.Context(), slog.String("room", roomID))
+    rm, ok := s.lookupRoom(roomID)
     if !ok {
         http.Error(w, "room not found", http.StatusNotFound)
         return
     }
 
-    switch tail {
-    case "messages":
-        if r.Method != http.MethodPost {
-            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-            return
-        }
+    // Posting is the one public action.
+    if tail == "messages" && sub == "" && r.Method == http.MethodPost {
         s.handlePostMessage(w, r, rm, roomID)
         return
+    }
+
+    // Everything below is a moderation action.
+    if !requireAdmin(w, r, rm) {
+        return
+    }
+    switch tail {
+    case "":
+        // DELETE /rooms/{id} closes the session for everyone
+        if r.Method != http.MethodDelete { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+        s.closeRoom(roomID, "closed by presenter")
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        json.NewEncoder(w).Encode(map[string]any{"ok": true})
+        return
     case "pause":
         if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
-        s.mu.Lock(); rm.Paused = true; s.mu.Unlock()
+        rm.mu.Lock(); err := s.updateRoom(rm, func(r *store.Room) error { r.Paused = true; return nil }); rm.mu.Unlock()
+        if err != nil { http.Error(w, "failed to save room", http.StatusInternalServerError); return }
+        rm.announce(event.NewPause(roomID, true))
         w.Header().Set("Content-Type", "application/json; charset=utf-8")
         json.NewEncoder(w).Encode(map[string]any{"ok": true, "paused": true})
         return
     case "resume":
         if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
-        s.mu.Lock(); rm.Paused = false; s.mu.Unlock()
+        rm.mu.Lock(); err := s.updateRoom(rm, func(r *store.Room) error { r.Paused = false; return nil }); rm.mu.Unlock()
+        if err != nil { http.Error(w, "failed to save room", http.StatusInternalServerError); return }
+        rm.announce(event.NewPause(roomID, false))
         w.Header().Set("Content-Type", "application/json; charset=utf-8")
         json.NewEncoder(w).Encode(map[string]any{"ok": true, "paused": false})
         return
     case "clear":
         if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
-        rm.Hub.Broadcast([]byte(`{"type":"clear"}`))
+        if err := rm.publish(event.NewClear(roomID)); err != nil { http.Error(w, "room busy", http.StatusServiceUnavailable); return }
         w.Header().Set("Content-Type", "application/json; charset=utf-8")
         json.NewEncoder(w).Encode(map[string]any{"ok": true})
         return
@@ -159,96 +493,35 @@ func (s *Server) handleRoomSubroutes(w http.ResponseWriter, r *http.Request) {
         var body struct{ Ms int `json:"ms"` }
         if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil { http.Error(w, "invalid json", http.StatusBadRequest); return }
         if body.Ms < 0 { body.Ms = 0 }
-        s.mu.Lock(); rm.SlowMode = time.Duration(body.Ms) * time.Millisecond; s.mu.Unlock()
+        rm.mu.Lock(); err := s.updateRoom(rm, func(r *store.Room) error { r.SlowModeMs = int64(body.Ms); return nil }); ev := rm.configEvent(); rm.mu.Unlock()
+        if err != nil { http.Error(w, "failed to save room", http.StatusInternalServerError); return }
+        rm.announce(ev)
         w.Header().Set("Content-Type", "application/json; charset=utf-8")
         json.NewEncoder(w).Encode(map[string]any{"ok": true, "slowModeMs": body.Ms})
         return
-    default:
-        http.NotFound(w, r)
+    case "settings":
+        s.handleSettings(w, r, rm)
         return
-    }
-}
-
-type postMessageReq struct {
-    Text   string `json:"text"`
-    Handle string `json:"handle"`
-}
-
-func (s *Server) handlePostMessage(w http.ResponseWriter, r *http.Request, rm *room, roomID string) {
-    body, err := io.ReadAll(io.LimitReader(r.Body, 4<<20)) // 4MB cap
-    if err != nil {
-        http.Error(w, "invalid body", http.StatusBadRequest)
-        return
-    }
-    var req postMessageReq
-    if err := json.Unmarshal(body, &req); err != nil {
-        http.Error(w, "invalid json", http.StatusBadRequest)
+    case "ngwords":
+        s.handleNGWords(w, r, rm, sub)
         return
-    }
-    req.Text = strings.TrimSpace(req.Text)
-    req.Handle = strings.TrimSpace(req.Handle)
-    if req.Text == "" {
-        http.Error(w, "text required", http.StatusBadRequest)
+    case "messages":
+        s.handleMessages(w, r, rm, sub)
         return
-    }
-    if len([]rune(req.Text)) > 200 {
-        http.Error(w, "text too long", http.StatusBadRequest)
+    case "pending":
+        s.handlePending(w, r, rm, sub)
         return
-    }
-    if len([]rune(req.Handle)) > 32 {
-        http.Error(w, "handle too long", http.StatusBadRequest)
+    case "bans":
+        s.handleBans(w, r, rm, sub)
         return
-    }
-
-    // NG word check
-    lower := strings.ToLower(req.Text)
-    for _, ng := range s.ngWords {
-        if ng == "" { continue }
-        if strings.Contains(lower, strings.ToLower(ng)) {
-            http.Error(w, "ng word detected", http.StatusForbidden)
-            return
-        }
-    }
-
-    // Check paused and apply slow mode as cooldown
-    s.mu.Lock()
-    paused := rm.Paused
-    slow := rm.SlowMode
-    s.mu.Unlock()
-    if paused {
-        http.Error(w, "paused", http.StatusLocked)
+    case "stats":
+        // GET /rooms/{id}/stats: how the room's hub is coping
+        if r.Method != http.MethodGet { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        json.NewEncoder(w).Encode(map[string]any{"ok": true, "hub": rm.Hub.Stats()})
         return
-    }
-
-    cooldown := 2 * time.Second
-    if slow > 0 {
-        cooldown = slow
-    }
-    identity := util.ClientIdentity(r, req.Handle)
-    now := time.Now()
-    s.mu.Lock()
-    if s.rate[roomID] == nil {
-        s.rate[roomID] = make(map[string]time.Time)
-    }
-    last := s.rate[roomID][identity]
-    if now.Sub(last) < cooldown {
-        s.mu.Unlock()
-        http.Error(w, "rate limited", http.StatusTooManyRequests)
+    default:
+        http.NotFound(w, r)
         return
     }
-    s.rate[roomID][identity] = now
-    s.mu.Unlock()
-
-    // Broadcast payload
-    payload := map[string]string{
-        "type":   "chat",
-        "text":   req.Text,
-        "handle": req.Handle,
-    }
-    b, _ := json.Marshal(payload)
-    rm.Hub.Broadcast(b)
-
-    w.Header().Set("Content-Type", "application/json; charset=utf-8")
-    w.WriteHeader(http.StatusAccepted)
-    json.NewEncoder(w).Encode(map[string]any{"ok": true})
 }
@@ -0,0 +1,419 @@
+package app
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+
+    "slideflow/internal/broker"
+    "slideflow/internal/config"
+    "slideflow/internal/ratelimit"
+    "slideflow/internal/store"
+)
+
+// newTestServer serves a standalone server on a memory store, without
+// the rate limits.
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+    t.Helper()
+    return newReplica(t, store.NewMemory(), nil)
+}
+
+// newReplica is newTestServer for one of several replicas sharing st and b.
+func newReplica(t *testing.T, st store.RoomStore, b broker.Broker) (*Server, *httptest.Server) {
+    t.Helper()
+    cfg := config.Default()
+    cfg.RateLimitIP, cfg.RateLimitRoom, cfg.RateLimitGlobal = ratelimit.Limit{}, ratelimit.Limit{}, ratelimit.Limit{}
+    s, err := NewServer(&cfg, st, b)
+    if err != nil {
+        t.Fatal(err)
+    }
+    ts := httptest.NewServer(s.Handler())
+    t.Cleanup(func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        s.Shutdown(ctx)
+        ts.Close()
+    })
+    return s, ts
+}
+
+// call sends a request and returns the response's status and body.
+// token, if set, authorises it as the room's admin.
+func call(t *testing.T, method, url, token, body string) (int, string) {
+    req, err := http.NewRequest(method, url, strings.NewReader(body))
+    if err != nil {
+        t.Error(err)
+        return 0, ""
+    }
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    if body != "" {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Error(err)
+        return 0, ""
+    }
+    defer resp.Body.Close()
+    b, _ := io.ReadAll(resp.Body)
+    return resp.StatusCode, string(b)
+}
+
+// TestConcurrentRooms posts to, moderates and watches many rooms at once,
+// for the race detector: go test -race ./internal/app
+func TestConcurrentRooms(t *testing.T) {
+    const (
+        rooms   = 12
+        viewers = 3
+        posters = 4
+        posts   = 25
+    )
+    s, ts := newTestServer(t)
+
+    type created struct{ ID, Token string }
+    var rs []created
+    for i := 0; i < rooms; i++ {
+        code, body := call(t, http.MethodPost, ts.URL+"/rooms", "", `{"settings":{"cooldownMs":0}}`)
+        if code != http.StatusOK {
+            t.Fatalf("create room: %d %s", code, body)
+        }
+        var resp struct {
+            RoomID     string `json:"roomId"`
+            AdminToken string `json:"adminToken"`
+        }
+        if err := json.Unmarshal([]byte(body), &resp); err != nil {
+            t.Fatal(err)
+        }
+        rs = append(rs, created{resp.RoomID, resp.AdminToken})
+    }
+
+    // Viewers read until the room's last post, "done".
+    wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/"
+    var watching sync.WaitGroup
+    for _, rm := range rs {
+        for v := 0; v < viewers; v++ {
+            c, _, err := websocket.DefaultDialer.Dial(wsURL+rm.ID, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer c.Close()
+            watching.Add(1)
+            go func(id string) {
+                defer watching.Done()
+                c.SetReadDeadline(time.Now().Add(20 * time.Second))
+                for {
+                    _, data, err := c.ReadMessage()
+                    if err != nil {
+                        t.Errorf("viewer of %s: %v", id, err)
+                        return
+                    }
+                    var ev struct{ Type, Text string }
+                    json.Unmarshal(data, &ev)
+                    if ev.Type == "chat" && ev.Text == "done" {
+                        return
+                    }
+                }
+            }(rm.ID)
+        }
+    }
+
+    // A 5xx other than a busy hub's 503 is a failure; the rest (paused,
+    // slow mode) depend on how the moderators' calls interleave.
+    check := func(what string, code int, body string) {
+        if code >= 500 && code != http.StatusServiceUnavailable || code == 0 {
+            t.Errorf("%s: %d %s", what, code, body)
+        }
+    }
+    var wg sync.WaitGroup
+    for _, rm := range rs {
+        base := ts.URL + "/rooms/" + rm.ID
+        for p := 0; p < posters; p++ {
+            wg.Add(1)
+            go func(p int) {
+                defer wg.Done()
+                for i := 0; i < posts; i++ {
+                    body := fmt.Sprintf(`{"text":"post %d","handle":"u%d"}`, i, p)
+                    code, resp := call(t, http.MethodPost, base+"/messages", "", body)
+                    check("post", code, resp)
+                }
+            }(p)
+        }
+        wg.Add(1)
+        go func(rm created) {
+            defer wg.Done()
+            for i := 0; i < posts; i++ {
+                patch := fmt.Sprintf(`{"maxTextLen":%d,"reviewMode":%v}`, 100+i, i%5 == 4)
+                code, resp := call(t, http.MethodPatch, base+"/settings", rm.Token, patch)
+                check("patch settings", code, resp)
+                code, resp = call(t, http.MethodPost, base+"/slowmode", rm.Token, fmt.Sprintf(`{"ms":%d}`, i%2*10))
+                check("slowmode", code, resp)
+                action := "/pause"
+                if i%2 == 1 {
+                    action = "/resume"
+                }
+                code, resp = call(t, http.MethodPost, base+action, rm.Token, "")
+                check(action, code, resp)
+            }
+        }(rm)
+        wg.Add(1)
+        go func(rm created) {
+            defer wg.Done()
+            for i := 0; i < posts; i++ {
+                for _, url := range []string{base + "/settings", base + "/messages", base + "/pending", base + "/stats", ts.URL + "/admin/" + rm.ID} {
+                    code, resp := call(t, http.MethodGet, url, rm.Token, "")
+                    check("GET "+url, code, resp)
+                }
+            }
+        }(rm)
+    }
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        for i := 0; i < posts; i++ {
+            code, resp := call(t, http.MethodGet, ts.URL+"/metrics", "", "")
+            check("metrics", code, resp)
+            s.reap(time.Now())
+        }
+    }()
+    wg.Wait()
+
+    for _, rm := range rs {
+        base := ts.URL + "/rooms/" + rm.ID
+        call(t, http.MethodPost, base+"/resume", rm.Token, "")
+        call(t, http.MethodPost, base+"/slowmode", rm.Token, `{"ms":0}`)
+        call(t, http.MethodPatch, base+"/settings", rm.Token, `{"reviewMode":false}`)
+        if code, resp := call(t, http.MethodPost, base+"/messages", "", `{"text":"done"}`); code != http.StatusAccepted {
+            t.Errorf("last post to %s: %d %s", rm.ID, code, resp)
+        }
+    }
+    watching.Wait()
+}
+
+func TestMetricsAccess(t *testing.T) {
+    _, ts := newTestServer(t)
+    tests := []struct {
+        name string
+        xff  string
+        want int
+    }{
+        {"local scrape", "", http.StatusOK},
+        {"remote client through a local proxy", "203.0.113.9", http.StatusForbidden},
+        {"local client through a local proxy", "127.0.0.1", http.StatusOK},
+    }
+    for _, tt := range tests {
+        req, _ := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+        if tt.xff != "" {
+            req.Header.Set("X-Forwarded-For", tt.xff)
+        }
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != tt.want {
+            t.Errorf("%s: %d, want %d", tt.name, resp.StatusCode, tt.want)
+        }
+    }
+}
+
+// TestReviewAcrossReplicas holds a comment on one replica and moderates it
+// from another, as a load balancer without sticky sessions would.
+func TestReviewAcrossReplicas(t *testing.T) {
+    st, b := store.NewMemory(), broker.NewMemory()
+    _, a := newReplica(t, st, b)
+    _, other := newReplica(t, st, b)
+
+    code, body := call(t, http.MethodPost, a.URL+"/rooms", "", `{"settings":{"reviewMode":true,"cooldownMs":0}}`)
+    if code != http.StatusOK {
+        t.Fatalf("create room: %d %s", code, body)
+    }
+    var rm struct {
+        RoomID     string `json:"roomId"`
+        AdminToken string `json:"adminToken"`
+    }
+    json.Unmarshal([]byte(body), &rm)
+
+    // Viewers on the other replica see only what a moderator lets through.
+    wsURL := "ws" + strings.TrimPrefix(other.URL, "http") + "/ws/" + rm.RoomID
+    c, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+
+    pending := func(base string) []string {
+        t.Helper()
+        code, body := call(t, http.MethodGet, base+"/rooms/"+rm.RoomID+"/pending", rm.AdminToken, "")
+        if code != http.StatusOK {
+            t.Fatalf("list pending: %d %s", code, body)
+        }
+        var resp struct {
+            Pending []struct{ Chat struct{ ID, Text string } }
+        }
+        json.Unmarshal([]byte(body), &resp)
+        var texts []string
+        for _, p := range resp.Pending {
+            texts = append(texts, p.Chat.ID+" "+p.Chat.Text)
+        }
+        return texts
+    }
+    for _, text := range []string{"keep", "drop"} {
+        if code, body := call(t, http.MethodPost, a.URL+"/rooms/"+rm.RoomID+"/messages", "", `{"text":"`+text+`"}`); code != http.StatusAccepted {
+            t.Fatalf("post %q: %d %s", text, code, body)
+        }
+    }
+    held := pending(other.URL)
+    if len(held) != 2 || !strings.HasSuffix(held[0], " keep") || !strings.HasSuffix(held[1], " drop") {
+        t.Fatalf("pending on the other replica = %v, want keep and drop", held)
+    }
+    keep, _, _ := strings.Cut(held[0], " ")
+    drop, _, _ := strings.Cut(held[1], " ")
+
+    base := other.URL + "/rooms/" + rm.RoomID + "/pending/"
+    if code, body := call(t, http.MethodPost, base+keep+"/approve", rm.AdminToken, ""); code != http.StatusOK {
+        t.Fatalf("approve: %d %s", code, body)
+    }
+    if code, _ := call(t, http.MethodPost, a.URL+"/rooms/"+rm.RoomID+"/pending/"+keep+"/approve", rm.AdminToken, ""); code != http.StatusNotFound {
+        t.Errorf("second approve: %d, want 404", code)
+    }
+    if code, body := call(t, http.MethodPost, base+drop+"/reject", rm.AdminToken, ""); code != http.StatusOK {
+        t.Fatalf("reject: %d %s", code, body)
+    }
+    if held := pending(a.URL); len(held) != 0 {
+        t.Errorf("pending after review = %v", held)
+    }
+
+    c.SetReadDeadline(time.Now().Add(5 * time.Second))
+    for {
+        _, data, err := c.ReadMessage()
+        if err != nil {
+            t.Fatalf("approved comment not delivered: %v", err)
+        }
+        var ev struct{ Type, Text string }
+        json.Unmarshal(data, &ev)
+        if ev.Type == "chat" {
+            if ev.Text != "keep" {
+                t.Errorf("viewer got %q, want keep", ev.Text)
+            }
+            break
+        }
+    }
+}
+
+// TestBanAcrossReplicas bans the poster of a comment shown through
+// another replica, by message ID.
+func TestBanAcrossReplicas(t *testing.T) {
+    st, b := store.NewMemory(), broker.NewMemory()
+    _, a := newReplica(t, st, b)
+    _, other := newReplica(t, st, b)
+
+    code, body := call(t, http.MethodPost, a.URL+"/rooms", "", `{"settings":{"cooldownMs":0}}`)
+    if code != http.StatusOK {
+        t.Fatalf("create room: %d %s", code, body)
+    }
+    var rm struct {
+        RoomID     string `json:"roomId"`
+        AdminToken string `json:"adminToken"`
+    }
+    json.Unmarshal([]byte(body), &rm)
+    // a viewer makes the other replica follow the room
+    c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(other.URL, "http")+"/ws/"+rm.RoomID, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+
+    post := a.URL + "/rooms/" + rm.RoomID + "/messages"
+    var id string
+    deadline := time.Now().Add(5 * time.Second)
+    for id == "" {
+        if time.Now().After(deadline) {
+            t.Fatal("chat not relayed")
+        }
+        // posts may go out before the other replica subscribes
+        if code, body := call(t, http.MethodPost, post, "", `{"text":"hello","handle":"troll"}`); code != http.StatusAccepted {
+            t.Fatalf("post: %d %s", code, body)
+        }
+        time.Sleep(10 * time.Millisecond)
+        _, body := call(t, http.MethodGet, other.URL+"/rooms/"+rm.RoomID+"/messages", rm.AdminToken, "")
+        var resp struct {
+            Messages []struct{ Chat struct{ ID string } }
+        }
+        json.Unmarshal([]byte(body), &resp)
+        if len(resp.Messages) > 0 {
+            id = resp.Messages[0].Chat.ID
+        }
+    }
+
+    code, body = call(t, http.MethodPost, other.URL+"/rooms/"+rm.RoomID+"/bans", rm.AdminToken, `{"messageId":"`+id+`"}`)
+    if code != http.StatusOK {
+        t.Fatalf("ban by message on the other replica: %d %s", code, body)
+    }
+    for {
+        code, _ := call(t, http.MethodPost, post, "", `{"text":"again","handle":"troll"}`)
+        if code == http.StatusForbidden {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("banned poster still posting: %d", code)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+// TestEditsAcrossReplicas changes a room on two replicas that haven't
+// heard of each other's changes; neither may undo the other's.
+func TestEditsAcrossReplicas(t *testing.T) {
+    // no broker, so neither replica reloads the room
+    st := store.NewMemory()
+    _, a := newReplica(t, st, nil)
+    _, other := newReplica(t, st, nil)
+
+    code, body := call(t, http.MethodPost, a.URL+"/rooms", "", "")
+    if code != http.StatusOK {
+        t.Fatalf("create room: %d %s", code, body)
+    }
+    var rm struct {
+        RoomID     string `json:"roomId"`
+        AdminToken string `json:"adminToken"`
+    }
+    json.Unmarshal([]byte(body), &rm)
+    // both replicas load the room before either changes it
+    for _, base := range []string{a.URL, other.URL} {
+        if code, body := call(t, http.MethodGet, base+"/rooms/"+rm.RoomID+"/bans", rm.AdminToken, ""); code != http.StatusOK {
+            t.Fatalf("list bans: %d %s", code, body)
+        }
+    }
+
+    edits := []struct{ base, method, path, body string }{
+        {a.URL, http.MethodPost, "/bans", `{"kind":"ip","value":"192.0.2.1"}`},
+        {other.URL, http.MethodPost, "/pause", ""},
+        {a.URL, http.MethodPost, "/ngwords", `{"kind":"word","pattern":"spam"}`},
+        {other.URL, http.MethodPatch, "/settings", `{"maxTextLen":50}`},
+        {a.URL, http.MethodPost, "/slowmode", `{"ms":3000}`},
+        {other.URL, http.MethodPost, "/bans", `{"kind":"ip","value":"192.0.2.2"}`},
+    }
+    for _, e := range edits {
+        if code, body := call(t, e.method, e.base+"/rooms/"+rm.RoomID+e.path, rm.AdminToken, e.body); code != http.StatusOK {
+            t.Fatalf("%s %s: %d %s", e.method, e.path, code, body)
+        }
+    }
+    rec, err := st.Get(rm.RoomID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(rec.Bans) != 2 || !rec.Paused || len(rec.NGRules) != 1 || rec.Settings.MaxTextLen != 50 || rec.SlowModeMs != 3000 {
+        t.Errorf("stored room lost an edit: %+v", rec)
+    }
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "slices"
+    "time"
+
+    "slideflow/internal/event"
+    "slideflow/internal/ngword"
+    "slideflow/internal/store"
+)
+
+const (
+    // maxPostBodyBytes caps the JSON body of a post; well above any text
+    // the settings below allow.
+    maxPostBodyBytes = 16 << 10
+
+    maxTextLenLimit   = 1000
+    maxHandleLenLimit = 64
+    maxCooldown       = 10 * time.Minute
+)
+
+// styleCommands are the style command groups a room can allow, see style.go.
+var styleCommands = []string{"color", "size", "position"}
+
+// ngModes select which NG lists apply: the server-wide list ("global"), the
+// room's own ("room"), both, or none ("off").
+var ngModes = []string{"global", "room", "both", "off"}
+
+func defaultSettings() store.Settings {
+    return store.Settings{
+        MaxTextLen:     200,
+        MaxHandleLen:   32,
+        AllowAnonymous: true,
+        CooldownMs:     2000,
+        AllowedStyles:  slices.Clone(styleCommands),
+        NGMode:         "both",
+        NGGlobalAction: string(ngword.ActionReject),
+    }
+}
+
+// settingsPatch is the body of PATCH /rooms/{id}/settings and the optional
+// "settings" of POST /rooms. Omitted fields are left unchanged.
+type settingsPatch struct {
+    MaxTextLen     *int      `json:"maxTextLen"`
+    MaxHandleLen   *int      `json:"maxHandleLen"`
+    AllowAnonymous *bool     `json:"allowAnonymous"`
+    CooldownMs     *int64    `json:"cooldownMs"`
+    AllowedStyles  *[]string `json:"allowedStyles"`
+    NGMode         *string   `json:"ngMode"`
+    NGGlobalAction *string   `json:"ngGlobalAction"`
+    ReviewMode     *bool     `json:"reviewMode"`
+}
+
+// apply returns st with the patch applied, or an error naming the first
+// invalid field. st itself is not modified.
+func (p settingsPatch) apply(st store.Settings) (store.Settings, error) {
+    st.AllowedStyles = slices.Clone(st.AllowedStyles)
+    if p.MaxTextLen != nil {
+        if *p.MaxTextLen < 1 || *p.MaxTextLen > maxTextLenLimit {
+            return st, fmt.Errorf("maxTextLen must be 1-%d", maxTextLenLimit)
+        }
+        st.MaxTextLen = *p.MaxTextLen
+    }
+    if p.MaxHandleLen != nil {
+        if *p.MaxHandleLen < 1 || *p.MaxHandleLen > maxHandleLenLimit {
+            return st, fmt.Errorf("maxHandleLen must be 1-%d", maxHandleLenLimit)
+        }
+        st.MaxHandleLen = *p.MaxHandleLen
+    }
+    if p.AllowAnonymous != nil {
+        st.AllowAnonymous = *p.AllowAnonymous
+    }
+    if p.CooldownMs != nil {
+        if *p.CooldownMs < 0 || time.Duration(*p.CooldownMs)*time.Millisecond > maxCooldown {
+            return st, fmt.Errorf("cooldownMs must be 0-%d", maxCooldown.Milliseconds())
+        }
+        st.CooldownMs = *p.CooldownMs
+    }
+    if p.AllowedStyles != nil {
+        styles := []string{}
+        for _, v := range *p.AllowedStyles {
+            if !slices.Contains(styleCommands, v) {
+                return st, fmt.Errorf("unknown style command %q", v)
+            }
+            if !slices.Contains(styles, v) {
+                styles = append(styles, v)
+            }
+        }
+        st.AllowedStyles = styles
+    }
+    if p.NGMode != nil {
+        if !slices.Contains(ngModes, *p.NGMode) {
+            return st, fmt.Errorf("unknown ngMode %q", *p.NGMode)
+        }
+        st.NGMode = *p.NGMode
+    }
+    if p.NGGlobalAction != nil {
+        switch a := ngword.Action(*p.NGGlobalAction); a {
+        case ngword.ActionReject, ngword.ActionMask, ngword.ActionHold:
+        default:
+            return st, fmt.Errorf("unknown ngGlobalAction %q", a)
+        }
+        st.NGGlobalAction = *p.NGGlobalAction
+    }
+    if p.ReviewMode != nil {
+        st.ReviewMode = *p.ReviewMode
+    }
+    return st, nil
+}
+
+// configEvent describes the room's current settings to clients. Callers
+// hold rm.mu.
+func (rm *room) configEvent() *event.Config {
+    ev := event.NewConfig(rm.ID)
+    ev.SlowModeMs = rm.SlowMode.Milliseconds()
+    ev.MaxTextLen = rm.Settings.MaxTextLen
+    ev.MaxHandleLen = rm.Settings.MaxHandleLen
+    ev.AllowAnonymous = rm.Settings.AllowAnonymous
+    ev.CooldownMs = rm.Settings.CooldownMs
+    ev.AllowedStyles = slices.Clone(rm.Settings.AllowedStyles)
+    ev.ReviewMode = rm.Settings.ReviewMode
+    return ev
+}
+
+// GET|PATCH /rooms/{id}/settings (admin). A successful PATCH pushes a config
+// event to every connected client.
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request, rm *room) {
+    switch r.Method {
+    case http.MethodGet:
+        rm.mu.Lock()
+        st := rm.Settings
+        rm.mu.Unlock()
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        json.NewEncoder(w).Encode(map[string]any{"ok": true, "settings": st})
+    case http.MethodPatch:
+        var patch settingsPatch
+        if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&patch); err != nil {
+            http.Error(w, "invalid json", http.StatusBadRequest)
+            return
+        }
+        rm.mu.Lock()
+        var st store.Settings
+        err := s.updateRoom(rm, func(r *store.Room) error {
+            var err error
+            if st, err = patch.apply(r.Settings); err != nil {
+                return &postError{Status: http.StatusBadRequest, Msg: err.Error()}
+            }
+            r.Settings = st
+            return nil
+        })
+        if perr := saveError(err); perr != nil {
+            rm.mu.Unlock()
+            http.Error(w, perr.Msg, perr.Status)
+            return
+        }
+        ev := rm.configEvent()
+        rm.mu.Unlock()
+        rm.announce(ev)
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        json.NewEncoder(w).Encode(map[string]any{"ok": true, "settings": st})
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    }
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "github.com/gorilla/websocket"
+
+    "slideflow/internal/event"
+    "slideflow/internal/hub"
+)
+
+// Shutdown prepares the server to exit. It stops taking new rooms, posts
+// and connections, tells every connected client the server is restarting,
+// and closes it with 1012 (service restart) so it reconnects, to another
+// replica if there is one. It returns once the close frames have gone out
+// and queued broker messages have been sent, or when ctx ends.
+//
+// Room state and the review queue are saved to the store as they change,
+// so only the store itself is left to close. Comments awaiting review
+// outlive the process with Redis; the memory and file stores keep them in
+// process only.
+func (s *Server) Shutdown(ctx context.Context) error {
+    s.draining.Store(true)
+    rooms := s.rooms.all()
+    for _, rm := range rooms {
+        s.unfollow(rm)
+        // Receive rather than publish: the other replicas aren't restarting.
+        notice := event.NewSystem(rm.ID, "warn", "restarting", "サーバーを再起動しています。自動的に再接続します。")
+        rm.Hub.Receive(hub.Frame{Data: event.Encode(notice)})
+        rm.Hub.Stop(websocket.CloseServiceRestart, "server restarting")
+    }
+
+    done := make(chan struct{})
+    go func() {
+        for _, rm := range rooms {
+            rm.Hub.Wait()
+        }
+        for len(s.outbox) > 0 {
+            time.Sleep(10 * time.Millisecond)
+        }
+        close(done)
+    }()
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// refuseDraining answers 503 once Shutdown has begun. It reports whether
+// it did.
+func (s *Server) refuseDraining(w http.ResponseWriter) bool {
+    if !s.draining.Load() {
+        return false
+    }
+    w.Header().Set("Retry-After", "1")
+    http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+    return true
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+    "net/http"
+    "slices"
+)
+
+// Comment style commands, niconico-style. Colors are picked by name from a
+// fixed palette so posters can't make comments invisible or unreadable with
+// arbitrary values; the broadcast event carries the hex value.
+var commentPalette = map[string]string{
+    "white":  "#ffffff",
+    "red":    "#ff0000",
+    "pink":   "#ff8080",
+    "orange": "#ffc000",
+    "yellow": "#ffff00",
+    "green":  "#00ff00",
+    "cyan":   "#00ffff",
+    "blue":   "#0000ff",
+    "purple": "#c000ff",
+    "black":  "#000000",
+}
+
+var commentSizes = map[string]bool{"small": true, "medium": true, "big": true}
+
+// Positions: naka scrolls right to left (the default), ue and shita stay
+// fixed at the top or bottom of the screen for a few seconds.
+var commentPositions = map[string]bool{"naka": true, "ue": true, "shita": true}
+
+// commentStyle is the validated style of a comment. Empty fields mean the
+// default (white, medium, naka) and are left out of the event.
+type commentStyle struct {
+    Color    string
+    Size     string
+    Position string
+}
+
+// parseStyle validates the optional style fields of a post against the
+// style commands the room allows.
+func parseStyle(req postMessageReq, allowed []string) (commentStyle, *postError) {
+    var st commentStyle
+    for _, c := range []struct{ name, val, def string }{
+        {"color", req.Color, "white"},
+        {"size", req.Size, "medium"},
+        {"position", req.Position, "naka"},
+    } {
+        if c.val != "" && c.val != c.def && !slices.Contains(allowed, c.name) {
+            return st, &postError{Status: http.StatusBadRequest, Msg: c.name + " not allowed"}
+        }
+    }
+    if req.Color != "" && req.Color != "white" {
+        hex, ok := commentPalette[req.Color]
+        if !ok {
+            return st, &postError{Status: http.StatusBadRequest, Msg: "invalid color"}
+        }
+        st.Color = hex
+    }
+    if req.Size != "" && req.Size != "medium" {
+        if !commentSizes[req.Size] {
+            return st, &postError{Status: http.StatusBadRequest, Msg: "invalid size"}
+        }
+        st.Size = req.Size
+    }
+    if req.Position != "" && req.Position != "naka" {
+        if !commentPositions[req.Position] {
+            return st, &postError{Status: http.StatusBadRequest, Msg: "invalid position"}
+        }
+        st.Position = req.Position
+    }
+    return st, nil
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+    "net/http"
+    "time"
+)
+
+// streamRoutes are the mux patterns whose requests hold the connection
+// open for as long as the client stays. WebSockets set a deadline per
+// frame once hijacked (see hub.Client) and SSE one per batch (see
+// sseDeadline), so the request timeouts don't apply to them.
+var streamRoutes = map[string]bool{
+    "/ws/":     true,
+    "/events/": true,
+}
+
+// timeouts bounds reading the body of and writing the response to every
+// other request. They are set here per request, not on http.Server, whose
+// WriteTimeout would cut streams off too.
+func (s *Server) timeouts(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if _, route := s.mux.Handler(r); !streamRoutes[route] {
+            rc := http.NewResponseController(w)
+            now := time.Now()
+            if s.readTimeout > 0 {
+                rc.SetReadDeadline(now.Add(s.readTimeout))
+            }
+            if s.writeTimeout > 0 {
+                rc.SetWriteDeadline(now.Add(s.writeTimeout))
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
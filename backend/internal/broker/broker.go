@@ -0,0 +1,102 @@
+// Package broker carries room traffic between replicas of the server. A
+// replica publishes every frame its hubs accept to the room's channel and
+// subscribes to the channels of rooms it has clients for.
+package broker
+
+import (
+    "errors"
+    "log/slog"
+    "sync"
+)
+
+// ErrClosed is returned after Close.
+var ErrClosed = errors.New("broker: closed")
+
+// Broker is a pub/sub bus plus the shared counters room sequence numbers
+// come from.
+type Broker interface {
+    // Publish sends msg to every subscriber of channel, including ones in
+    // this process. Delivery is at most once.
+    Publish(channel string, msg []byte) error
+    // Subscribe calls fn with each message on channel, in order, on a
+    // goroutine of its own. Messages are dropped while fn falls more than
+    // SubscriptionBuffer behind. cancel stops the subscription.
+    Subscribe(channel string, fn func(msg []byte)) (cancel func(), err error)
+    // Incr adds one to the counter at key and returns the new value; a
+    // missing key counts from zero.
+    Incr(key string) (uint64, error)
+    // Del removes the counter at key.
+    Del(key string) error
+    // Ping reports whether the broker is reachable, for readiness checks.
+    Ping() error
+    Close() error
+}
+
+// SubscriptionBuffer is how many messages a subscription holds for fn.
+const SubscriptionBuffer = 256
+
+// subscription runs one Subscribe callback.
+type subscription struct {
+    channel string
+    fn      func([]byte)
+    msgs    chan []byte
+    done    chan struct{}
+    once    sync.Once
+}
+
+func newSubscription(channel string, fn func([]byte)) *subscription {
+    sub := &subscription{channel: channel, fn: fn, msgs: make(chan []byte, SubscriptionBuffer), done: make(chan struct{})}
+    go sub.run()
+    return sub
+}
+
+func (sub *subscription) run() {
+    for {
+        select {
+        case msg := <-sub.msgs:
+            sub.fn(msg)
+        case <-sub.done:
+            return
+        }
+    }
+}
+
+// deliver hands msg to the callback without blocking the publisher.
+func (sub *subscription) deliver(msg []byte) {
+    select {
+    case sub.msgs <- msg:
+    case <-sub.done:
+    default:
+        slog.Warn("broker subscriber behind, message dropped", "channel", sub.channel)
+    }
+}
+
+func (sub *subscription) stop() {
+    sub.once.Do(func() { close(sub.done) })
+}
+
+// topics tracks subscriptions by channel.
+type topics map[string]map[*subscription]bool
+
+func (t topics) add(sub *subscription) (first bool) {
+    subs := t[sub.channel]
+    if subs == nil {
+        subs = make(map[*subscription]bool)
+        t[sub.channel] = subs
+    }
+    subs[sub] = true
+    return len(subs) == 1
+}
+
+func (t topics) remove(sub *subscription) (last bool) {
+    subs, ok := t[sub.channel]
+    if !ok || !subs[sub] {
+        return false
+    }
+    delete(subs, sub)
+    if len(subs) == 0 {
+        delete(t, sub.channel)
+        return true
+    }
+    return false
+}
@@ -0,0 +1,156 @@
+package broker
+
+import (
+    "testing"
+    "time"
+
+    "slideflow/internal/resp/resptest"
+)
+
+// receive waits for the next message on msgs.
+func receive(t *testing.T, msgs <-chan string, want string) {
+    t.Helper()
+    select {
+    case got := <-msgs:
+        if got != want {
+            t.Fatalf("got %q, want %q", got, want)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatalf("%q not delivered", want)
+    }
+}
+
+// publishUntil publishes msg until one arrives, for subscriptions that
+// take effect asynchronously, then discards any further copies.
+func publishUntil(t *testing.T, b Broker, channel, msg string, msgs <-chan string) {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for time.Now().Before(deadline) {
+        b.Publish(channel, []byte(msg))
+        select {
+        case got := <-msgs:
+            if got != msg {
+                t.Fatalf("got %q, want %q", got, msg)
+            }
+            time.Sleep(50 * time.Millisecond)
+            for len(msgs) > 0 {
+                receive(t, msgs, msg)
+            }
+            return
+        case <-time.After(50 * time.Millisecond):
+        }
+    }
+    t.Fatalf("%q never delivered", msg)
+}
+
+func TestBrokers(t *testing.T) {
+    pairs := map[string]func(t *testing.T) (Broker, Broker){
+        "memory": func(t *testing.T) (Broker, Broker) {
+            m := NewMemory()
+            return m, m
+        },
+        "redis": func(t *testing.T) (Broker, Broker) {
+            srv := resptest.NewServer(t)
+            a, err := DialRedis(srv.URL())
+            if err != nil {
+                t.Fatal(err)
+            }
+            b, err := DialRedis(srv.URL())
+            if err != nil {
+                t.Fatal(err)
+            }
+            return a, b
+        },
+    }
+    for name, open := range pairs {
+        t.Run(name, func(t *testing.T) {
+            a, b := open(t)
+            defer a.Close()
+            defer b.Close()
+
+            msgs := make(chan string, 16)
+            cancel, err := b.Subscribe("room:1", func(msg []byte) { msgs <- string(msg) })
+            if err != nil {
+                t.Fatal(err)
+            }
+            publishUntil(t, a, "room:1", "first", msgs)
+            for _, m := range []string{"x", "y", "z"} {
+                a.Publish("room:1", []byte(m))
+            }
+            for _, m := range []string{"x", "y", "z"} {
+                receive(t, msgs, m)
+            }
+
+            // a second subscriber to the same channel gets its own copy
+            other := make(chan string, 16)
+            cancelOther, _ := a.Subscribe("room:1", func(msg []byte) { other <- string(msg) })
+            publishUntil(t, a, "room:1", "both", other)
+            receive(t, msgs, "both")
+            // publishUntil may have sent more than one
+            time.Sleep(50 * time.Millisecond)
+            for len(msgs) > 0 {
+                receive(t, msgs, "both")
+            }
+            cancelOther()
+            cancel()
+            a.Publish("room:1", []byte("nobody"))
+            time.Sleep(50 * time.Millisecond)
+            if len(msgs) > 0 || len(other) > 0 {
+                t.Error("delivered after cancel")
+            }
+
+            for want := uint64(1); want <= 3; want++ {
+                if n, err := a.Incr("seq"); err != nil || n != want {
+                    t.Fatalf("Incr = %d, %v; want %d", n, err, want)
+                }
+            }
+            if n, _ := b.Incr("seq"); n != 4 {
+                t.Errorf("Incr from the other broker = %d, want 4", n)
+            }
+            if err := a.Del("seq"); err != nil {
+                t.Fatal(err)
+            }
+            if n, _ := a.Incr("seq"); n != 1 {
+                t.Errorf("Incr after Del = %d, want 1", n)
+            }
+            if err := a.Ping(); err != nil {
+                t.Error(err)
+            }
+        })
+    }
+}
+
+func TestRedisResubscribes(t *testing.T) {
+    srv := resptest.NewServer(t)
+    a, err := DialRedis(srv.URL())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer a.Close()
+    b, err := DialRedis(srv.URL())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer b.Close()
+
+    msgs := make(chan string, 16)
+    b.Subscribe("room:1", func(msg []byte) { msgs <- string(msg) })
+    publishUntil(t, a, "room:1", "before", msgs)
+
+    srv.DropConnections()
+    // subscribed again without being asked, and ready once it is
+    deadline := time.Now().Add(5 * time.Second)
+    for b.Ping() != nil && time.Now().Before(deadline) {
+        time.Sleep(20 * time.Millisecond)
+    }
+    if err := b.Ping(); err != nil {
+        t.Fatalf("not ready after reconnecting: %v", err)
+    }
+    publishUntil(t, a, "room:1", "after", msgs)
+
+    srv.Close()
+    time.Sleep(50 * time.Millisecond)
+    if err := b.Ping(); err == nil {
+        t.Error("ready with the server gone")
+    }
+}
@@ -0,0 +1,84 @@
+package broker
+
+import (
+    "slices"
+    "sync"
+)
+
+// Memory is a Broker within one process. It links Servers that share it,
+// which is what tests and single-binary setups need.
+type Memory struct {
+    mu     sync.Mutex
+    topics topics
+    counts map[string]uint64
+    closed bool
+}
+
+func NewMemory() *Memory {
+    return &Memory{topics: make(topics), counts: make(map[string]uint64)}
+}
+
+func (m *Memory) Publish(channel string, msg []byte) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.closed {
+        return ErrClosed
+    }
+    msg = slices.Clone(msg)
+    for sub := range m.topics[channel] {
+        sub.deliver(msg)
+    }
+    return nil
+}
+
+func (m *Memory) Subscribe(channel string, fn func([]byte)) (func(), error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.closed {
+        return nil, ErrClosed
+    }
+    sub := newSubscription(channel, fn)
+    m.topics.add(sub)
+    return func() {
+        m.mu.Lock()
+        m.topics.remove(sub)
+        m.mu.Unlock()
+        sub.stop()
+    }, nil
+}
+
+func (m *Memory) Incr(key string) (uint64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.counts[key]++
+    return m.counts[key], nil
+}
+
+func (m *Memory) Del(key string) error {
+    m.mu.Lock()
+    delete(m.counts, key)
+    m.mu.Unlock()
+    return nil
+}
+
+func (m *Memory) Ping() error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.closed {
+        return ErrClosed
+    }
+    return nil
+}
+
+func (m *Memory) Close() error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.closed = true
+    for _, subs := range m.topics {
+        for sub := range subs {
+            sub.stop()
+        }
+    }
+    m.topics = make(topics)
+    return nil
+}
@@ -0,0 +1,89 @@
+package broker
+
+import (
+    "errors"
+    "sync"
+
+    "slideflow/internal/resp"
+)
+
+// Redis is a Broker on a Redis server (or anything speaking its protocol):
+// PUBLISH/SUBSCRIBE for channels and INCR for counters. It uses one
+// connection for commands and one for subscriptions.
+type Redis struct {
+    cl *resp.Client
+    ps *resp.PubSub
+
+    mu     sync.Mutex
+    topics topics
+}
+
+// DialRedis connects to the server at a redis:// URL.
+func DialRedis(rawURL string) (*Redis, error) {
+    cl, err := resp.Dial(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    r := &Redis{cl: cl, topics: make(topics)}
+    r.ps = resp.NewPubSub(cl.Options(), r.dispatch)
+    return r, nil
+}
+
+// dispatch runs on the subscriber connection's read loop.
+func (r *Redis) dispatch(channel string, msg []byte) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for sub := range r.topics[channel] {
+        sub.deliver(msg)
+    }
+}
+
+func (r *Redis) Publish(channel string, msg []byte) error {
+    _, err := r.cl.Do("PUBLISH", channel, string(msg))
+    return err
+}
+
+func (r *Redis) Subscribe(channel string, fn func([]byte)) (func(), error) {
+    sub := newSubscription(channel, fn)
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.topics.add(sub) {
+        // A failed write is retried when the connection comes back.
+        r.ps.Subscribe(channel)
+    }
+    return func() {
+        r.mu.Lock()
+        defer r.mu.Unlock()
+        if r.topics.remove(sub) {
+            r.ps.Unsubscribe(channel)
+        }
+        sub.stop()
+    }, nil
+}
+
+func (r *Redis) Incr(key string) (uint64, error) {
+    n, err := r.cl.Int("INCR", key)
+    return uint64(n), err
+}
+
+func (r *Redis) Del(key string) error {
+    _, err := r.cl.Do("DEL", key)
+    return err
+}
+
+// Ping checks both connections: a replica that can publish but not
+// receive would miss other replicas' frames.
+func (r *Redis) Ping() error {
+    if _, err := r.cl.Do("PING"); err != nil {
+        return err
+    }
+    if !r.ps.Connected() {
+        return errors.New("broker: subscriber connection down")
+    }
+    return nil
+}
+
+func (r *Redis) Close() error {
+    r.ps.Close()
+    return r.cl.Close()
+}
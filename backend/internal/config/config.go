@@ -0,0 +1,434 @@
+// Package config gathers the server's settings from, in increasing order
+// of precedence, built-in defaults, a TOML config file, environment
+// variables and command-line flags, and checks them before anything
+// starts.
+package config
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "slideflow/internal/hub"
+    "slideflow/internal/ngword"
+    "slideflow/internal/ratelimit"
+    "slideflow/internal/util"
+)
+
+// FileEnv names the config file when the -config flag isn't given.
+const FileEnv = "CONFIG_FILE"
+
+// Config is every setting the server reads at startup.
+type Config struct {
+    // HTTP server. ReadTimeout and WriteTimeout bound ordinary requests;
+    // WebSocket and SSE streams keep their own per-write deadlines.
+    Port              int
+    ReadHeaderTimeout time.Duration
+    ReadTimeout       time.Duration
+    WriteTimeout      time.Duration
+    IdleTimeout       time.Duration
+    ShutdownTimeout   time.Duration
+    // HTTP2 is offered to TLS clients unless turned off
+    HTTP2 bool
+
+    // TLS serves HTTPS on Port when both files are set; they are
+    // reloaded when they change. RedirectPort, if set, listens for plain
+    // HTTP and redirects it to HTTPS.
+    TLSCertFile  string
+    TLSKeyFile   string
+    RedirectPort int
+
+    // Logging, see util.NewLogger
+    LogLevel  string
+    LogFormat string
+
+    // Storage: RedisURL wins over DataFile; with neither rooms are kept
+    // in memory only
+    DataFile string
+    RedisURL string
+
+    // Rooms: RoomTTL deletes rooms, RoomIdleTimeout only unloads them
+    // from memory; zero disables either
+    RoomTTL         time.Duration
+    RoomIdleTimeout time.Duration
+    RoomIDLength    int
+    // QRSize is the side of the post URL's QR code in pixels and QRLevel
+    // its error correction: low, medium, high or highest
+    QRSize  int
+    QRLevel string
+
+    // NGWords is the server-wide NG list, as substring rules
+    NGWords []string
+    // TrustedProxies are the CIDRs or addresses whose forwarding headers
+    // are believed; empty ignores those headers. ProxyHeader is the header
+    // family they set, util.HeaderXForwarded or util.HeaderForwarded.
+    TrustedProxies []string
+    ProxyHeader    string
+
+    // Post rate limits on top of each room's cooldown
+    RateLimitIP     ratelimit.Limit
+    RateLimitRoom   ratelimit.Limit
+    RateLimitGlobal ratelimit.Limit
+
+    // Hub backpressure, see hub.Config
+    HubBackpressure hub.Policy
+    HubSendBuffer   int
+
+    // MetricsToken, if set, guards /metrics with a bearer token; without
+    // one, only clients in MetricsAllow may scrape
+    MetricsToken string
+    MetricsAllow []string
+}
+
+// Default returns the settings used when nothing else is configured.
+func Default() Config {
+    return Config{
+        Port:              8080,
+        ReadHeaderTimeout: 5 * time.Second,
+        ReadTimeout:       10 * time.Second,
+        WriteTimeout:      10 * time.Second,
+        IdleTimeout:       60 * time.Second,
+        ShutdownTimeout:   10 * time.Second,
+        HTTP2:             true,
+        LogLevel:          "info",
+        LogFormat:         "json",
+        RoomTTL:           24 * time.Hour,
+        RoomIdleTimeout:   2 * time.Hour,
+        RoomIDLength:      10,
+        QRSize:            256,
+        QRLevel:           "medium",
+        NGWords:           []string{"死ね", "fuck", "shit"},
+        // a proxy on the same host, which only local processes could
+        // impersonate
+        TrustedProxies:    []string{"127.0.0.0/8", "::1"},
+        ProxyHeader:       util.HeaderXForwarded,
+        // Per IP is generous because a venue's Wi-Fi often puts the whole
+        // audience behind one address; per room keeps overlays readable
+        // however many people post.
+        RateLimitIP:       ratelimit.Limit{Rate: 10, Burst: 20},
+        RateLimitRoom:     ratelimit.Limit{Rate: 20, Burst: 40},
+        RateLimitGlobal:   ratelimit.Limit{Rate: 200, Burst: 400},
+        HubBackpressure:   hub.PolicyDropOldest,
+        HubSendBuffer:     hub.DefaultSendBuffer,
+        // metrics label rooms by ID, so only the host itself by default
+        MetricsAllow:      []string{"127.0.0.0/8", "::1"},
+    }
+}
+
+// TLS reports whether the server serves HTTPS itself.
+func (c *Config) TLS() bool { return c.TLSCertFile != "" && c.TLSKeyFile != "" }
+
+// setting ties one Config field to its file key, environment variable and
+// flag. set gets a single value for scalars; list settings get the file's
+// array or the comma-separated parts of the env var or flag.
+type setting struct {
+    key   string // table.key in the file
+    env   string
+    flag  string
+    usage string
+    list  bool
+    // clear means an empty env var empties the setting rather than
+    // being ignored
+    clear bool
+    // boolean lets the flag stand alone for true, as -http2
+    boolean bool
+    set     func(c *Config, v []string) error
+}
+
+var settings = []setting{
+    {key: "server.port", env: "PORT", flag: "port", usage: "port to listen on",
+        set: scalar(func(c *Config) *int { return &c.Port }, strconv.Atoi)},
+    {key: "server.read_header_timeout", env: "HTTP_READ_HEADER_TIMEOUT", flag: "read-header-timeout", usage: "time to read request headers (0 for none)",
+        set: scalar(func(c *Config) *time.Duration { return &c.ReadHeaderTimeout }, time.ParseDuration)},
+    {key: "server.read_timeout", env: "HTTP_READ_TIMEOUT", flag: "read-timeout", usage: "time to read a request body, except on streams (0 for none)",
+        set: scalar(func(c *Config) *time.Duration { return &c.ReadTimeout }, time.ParseDuration)},
+    {key: "server.write_timeout", env: "HTTP_WRITE_TIMEOUT", flag: "write-timeout", usage: "time to write a response, except on streams (0 for none)",
+        set: scalar(func(c *Config) *time.Duration { return &c.WriteTimeout }, time.ParseDuration)},
+    {key: "server.idle_timeout", env: "HTTP_IDLE_TIMEOUT", flag: "idle-timeout", usage: "time to keep an idle keep-alive connection (0 for none)",
+        set: scalar(func(c *Config) *time.Duration { return &c.IdleTimeout }, time.ParseDuration)},
+    {key: "server.shutdown_timeout", env: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", usage: "time to drain clients on SIGTERM/SIGINT",
+        set: scalar(func(c *Config) *time.Duration { return &c.ShutdownTimeout }, time.ParseDuration)},
+    {key: "server.http2", env: "HTTP2", flag: "http2", usage: "offer HTTP/2 over TLS (-http2=false for HTTP/1.1 only)", boolean: true,
+        set: scalar(func(c *Config) *bool { return &c.HTTP2 }, strconv.ParseBool)},
+    {key: "tls.cert_file", env: "TLS_CERT_FILE", flag: "tls-cert", usage: "PEM certificate chain; serves HTTPS with tls.key_file",
+        set: scalar(func(c *Config) *string { return &c.TLSCertFile }, parseString)},
+    {key: "tls.key_file", env: "TLS_KEY_FILE", flag: "tls-key", usage: "PEM private key for tls.cert_file",
+        set: scalar(func(c *Config) *string { return &c.TLSKeyFile }, parseString)},
+    {key: "tls.redirect_port", env: "TLS_REDIRECT_PORT", flag: "tls-redirect-port", usage: "port redirecting plain HTTP to HTTPS (0 for none)",
+        set: scalar(func(c *Config) *int { return &c.RedirectPort }, strconv.Atoi)},
+    {key: "log.level", env: "LOG_LEVEL", flag: "log-level", usage: "debug, info, warn or error",
+        set: scalar(func(c *Config) *string { return &c.LogLevel }, parseString)},
+    {key: "log.format", env: "LOG_FORMAT", flag: "log-format", usage: "json or text",
+        set: scalar(func(c *Config) *string { return &c.LogFormat }, parseString)},
+    {key: "store.data_file", env: "DATA_FILE", flag: "data-file", usage: "JSON file to keep rooms in",
+        set: scalar(func(c *Config) *string { return &c.DataFile }, parseString)},
+    {key: "store.redis_url", env: "REDIS_URL", flag: "redis-url", usage: "redis:// URL to keep rooms in and link replicas through",
+        set: scalar(func(c *Config) *string { return &c.RedisURL }, parseString)},
+    {key: "rooms.ttl", env: "ROOM_TTL", flag: "room-ttl", usage: "close rooms this long after creation (0 for never)",
+        set: scalar(func(c *Config) *time.Duration { return &c.RoomTTL }, time.ParseDuration)},
+    {key: "rooms.idle_timeout", env: "ROOM_IDLE_TIMEOUT", flag: "room-idle-timeout", usage: "unload rooms without clients or posts for this long from memory (0 for never)",
+        set: scalar(func(c *Config) *time.Duration { return &c.RoomIdleTimeout }, time.ParseDuration)},
+    {key: "rooms.id_length", env: "ROOM_ID_LENGTH", flag: "room-id-length", usage: "characters in new room IDs",
+        set: scalar(func(c *Config) *int { return &c.RoomIDLength }, strconv.Atoi)},
+    {key: "rooms.qr_size", env: "QR_SIZE", flag: "qr-size", usage: "QR code size in pixels",
+        set: scalar(func(c *Config) *int { return &c.QRSize }, strconv.Atoi)},
+    {key: "rooms.qr_level", env: "QR_LEVEL", flag: "qr-level", usage: "QR error correction: low, medium, high or highest",
+        set: scalar(func(c *Config) *string { return &c.QRLevel }, parseString)},
+    {key: "moderation.ng_words", env: "NG_WORDS", flag: "ng-words", usage: "server-wide NG words, comma-separated", list: true,
+        set: list(func(c *Config) *[]string { return &c.NGWords })},
+    {key: "moderation.trusted_proxies", env: "TRUSTED_PROXIES", flag: "trusted-proxies", usage: "proxies whose forwarding headers are believed, comma-separated", list: true, clear: true,
+        set: list(func(c *Config) *[]string { return &c.TrustedProxies })},
+    {key: "moderation.proxy_header", env: "PROXY_HEADER", flag: "proxy-header", usage: "header the trusted proxies set: x-forwarded or forwarded",
+        set: scalar(func(c *Config) *string { return &c.ProxyHeader }, parseString)},
+    {key: "limits.ip", env: "RATE_LIMIT_IP", flag: "rate-limit-ip", usage: "posts per IP, e.g. 10/s:20, or off",
+        set: scalar(func(c *Config) *ratelimit.Limit { return &c.RateLimitIP }, ratelimit.ParseLimit)},
+    {key: "limits.room", env: "RATE_LIMIT_ROOM", flag: "rate-limit-room", usage: "posts per room, e.g. 20/s:40, or off",
+        set: scalar(func(c *Config) *ratelimit.Limit { return &c.RateLimitRoom }, ratelimit.ParseLimit)},
+    {key: "limits.global", env: "RATE_LIMIT_GLOBAL", flag: "rate-limit-global", usage: "posts per server, e.g. 200/s:400, or off",
+        set: scalar(func(c *Config) *ratelimit.Limit { return &c.RateLimitGlobal }, ratelimit.ParseLimit)},
+    {key: "hub.backpressure", env: "HUB_BACKPRESSURE", flag: "hub-backpressure", usage: "drop-oldest, coalesce or disconnect",
+        set: scalar(func(c *Config) *hub.Policy { return &c.HubBackpressure }, hub.ParsePolicy)},
+    {key: "hub.send_buffer", env: "HUB_SEND_BUFFER", flag: "hub-send-buffer", usage: "frames a client may have queued",
+        set: scalar(func(c *Config) *int { return &c.HubSendBuffer }, strconv.Atoi)},
+    {key: "metrics.token", env: "METRICS_TOKEN", flag: "metrics-token", usage: "bearer token for /metrics",
+        set: scalar(func(c *Config) *string { return &c.MetricsToken }, parseString)},
+    {key: "metrics.allow", env: "METRICS_ALLOW", flag: "metrics-allow", usage: "networks that may scrape /metrics without the token, comma-separated", list: true, clear: true,
+        set: list(func(c *Config) *[]string { return &c.MetricsAllow })},
+}
+
+func scalar[T any](field func(*Config) *T, parse func(string) (T, error)) func(*Config, []string) error {
+    return func(c *Config, v []string) error {
+        if len(v) != 1 {
+            return errors.New("want a single value, not a list")
+        }
+        x, err := parse(strings.TrimSpace(v[0]))
+        if err != nil {
+            return err
+        }
+        *field(c) = x
+        return nil
+    }
+}
+
+func list(field func(*Config) *[]string) func(*Config, []string) error {
+    return func(c *Config, v []string) error {
+        out := []string{}
+        for _, s := range v {
+            if s = strings.TrimSpace(s); s != "" {
+                out = append(out, s)
+            }
+        }
+        *field(c) = out
+        return nil
+    }
+}
+
+func parseString(s string) (string, error) { return s, nil }
+
+// boolFlag is a string flag that may stand alone for "true". Like the
+// others it keeps the text for Load to parse in turn.
+type boolFlag string
+
+func (f *boolFlag) String() string     { return string(*f) }
+func (f *boolFlag) Set(s string) error { *f = boolFlag(s); return nil }
+func (f *boolFlag) IsBoolFlag() bool   { return true }
+
+// Load builds the config from args (without the program name) and the
+// environment. The file is named by -config or CONFIG_FILE; a missing
+// file is an error only when named. -h prints every flag with its
+// environment variable and returns flag.ErrHelp.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+    c := Default()
+
+    fs := flag.NewFlagSet("slideflow", flag.ContinueOnError)
+    fs.SetOutput(io.Discard)
+    file := fs.String("config", "", "TOML config file (env "+FileEnv+")")
+    flags := map[string]*setting{}
+    for i := range settings {
+        st := &settings[i]
+        usage := fmt.Sprintf("%s (env %s, file %s)", st.usage, st.env, st.key)
+        if st.boolean {
+            fs.Var(new(boolFlag), st.flag, usage)
+        } else {
+            fs.String(st.flag, "", usage)
+        }
+        flags[st.flag] = st
+    }
+    if err := fs.Parse(args); err != nil {
+        if errors.Is(err, flag.ErrHelp) {
+            fs.SetOutput(os.Stderr)
+            fs.PrintDefaults()
+            return nil, err
+        }
+        return nil, fmt.Errorf("config: %w", err)
+    }
+    if fs.NArg() > 0 {
+        return nil, fmt.Errorf("config: unexpected argument %q", fs.Arg(0))
+    }
+
+    // file
+    path := *file
+    if path == "" {
+        path, _ = lookupEnv(FileEnv)
+    }
+    if path != "" {
+        if err := c.loadFile(path); err != nil {
+            return nil, err
+        }
+    }
+
+    // environment
+    for _, st := range settings {
+        v, ok := lookupEnv(st.env)
+        if !ok || (v == "" && !st.clear) {
+            continue
+        }
+        if err := st.set(&c, split(st, v)); err != nil {
+            return nil, fmt.Errorf("config: %s=%q: %w", st.env, v, err)
+        }
+    }
+
+    // flags
+    var err error
+    fs.Visit(func(f *flag.Flag) {
+        st, ok := flags[f.Name]
+        if !ok || err != nil {
+            return
+        }
+        v := f.Value.String()
+        if e := st.set(&c, split(*st, v)); e != nil {
+            err = fmt.Errorf("config: -%s=%q: %w", f.Name, v, e)
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if err := c.Validate(); err != nil {
+        return nil, err
+    }
+    return &c, nil
+}
+
+func split(st setting, v string) []string {
+    if st.list {
+        return strings.Split(v, ",")
+    }
+    return []string{v}
+}
+
+func (c *Config) loadFile(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return fmt.Errorf("config: %w", err)
+    }
+    vals, err := parseFile(data)
+    if err != nil {
+        return fmt.Errorf("config: %s: %w", path, err)
+    }
+    byKey := map[string]setting{}
+    for _, st := range settings {
+        byKey[st.key] = st
+    }
+    // in file order, so the first mistake is the one reported
+    keys := make([]string, 0, len(vals))
+    for k := range vals {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool { return vals[keys[i]].line < vals[keys[j]].line })
+    for _, k := range keys {
+        v := vals[k]
+        st, ok := byKey[k]
+        if !ok {
+            return fmt.Errorf("config: %s:%d: unknown setting %s", path, v.line, k)
+        }
+        if st.list && !v.list {
+            v.vals = strings.Split(v.vals[0], ",")
+        }
+        if err := st.set(c, v.vals); err != nil {
+            return fmt.Errorf("config: %s:%d: %s: %w", path, v.line, k, err)
+        }
+    }
+    return nil
+}
+
+// Validate checks the values against each other and their ranges.
+func (c *Config) Validate() error {
+    fail := func(key, format string, a ...any) error {
+        return fmt.Errorf("config: %s: "+format, append([]any{key}, a...)...)
+    }
+    if c.Port < 1 || c.Port > 65535 {
+        return fail("server.port", "%d is not a port", c.Port)
+    }
+    for key, d := range map[string]time.Duration{
+        "server.read_header_timeout": c.ReadHeaderTimeout,
+        "server.read_timeout":        c.ReadTimeout,
+        "server.write_timeout":       c.WriteTimeout,
+        "server.idle_timeout":        c.IdleTimeout,
+        "rooms.ttl":                  c.RoomTTL,
+        "rooms.idle_timeout":         c.RoomIdleTimeout,
+    } {
+        if d < 0 {
+            return fail(key, "must not be negative")
+        }
+    }
+    if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+        return fail("tls", "cert_file and key_file go together")
+    }
+    if c.RedirectPort != 0 {
+        switch {
+        case c.RedirectPort < 0 || c.RedirectPort > 65535:
+            return fail("tls.redirect_port", "%d is not a port", c.RedirectPort)
+        case !c.TLS():
+            return fail("tls.redirect_port", "needs tls.cert_file and tls.key_file")
+        case c.RedirectPort == c.Port:
+            return fail("tls.redirect_port", "must differ from server.port")
+        }
+    }
+    if c.ShutdownTimeout <= 0 {
+        return fail("server.shutdown_timeout", "must be positive")
+    }
+    var lv slog.Level
+    if err := lv.UnmarshalText([]byte(c.LogLevel)); err != nil {
+        return fail("log.level", "%q: want debug, info, warn or error", c.LogLevel)
+    }
+    if c.LogFormat != "json" && c.LogFormat != "text" {
+        return fail("log.format", "%q: want json or text", c.LogFormat)
+    }
+    if c.RoomIDLength < 6 || c.RoomIDLength > 64 {
+        return fail("rooms.id_length", "%d: want 6 to 64", c.RoomIDLength)
+    }
+    if c.QRSize < 64 || c.QRSize > 2048 {
+        return fail("rooms.qr_size", "%d: want 64 to 2048", c.QRSize)
+    }
+    switch c.QRLevel {
+    case "low", "medium", "high", "highest":
+    default:
+        return fail("rooms.qr_level", "%q: want low, medium, high or highest", c.QRLevel)
+    }
+    for _, w := range c.NGWords {
+        if err := (ngword.Rule{Kind: ngword.KindSubstring, Pattern: w}).Validate(); err != nil {
+            return fail("moderation.ng_words", "%v", err)
+        }
+    }
+    if _, err := util.ParseProxies("", c.ProxyHeader); err != nil {
+        return fail("moderation.proxy_header", "%v", err)
+    }
+    if _, err := util.ParseProxies(strings.Join(c.TrustedProxies, ","), c.ProxyHeader); err != nil {
+        return fail("moderation.trusted_proxies", "%v", err)
+    }
+    if c.HubSendBuffer < 1 {
+        return fail("hub.send_buffer", "must be positive")
+    }
+    if _, err := util.ParseNetworks(strings.Join(c.MetricsAllow, ",")); err != nil {
+        return fail("metrics.allow", "%v", err)
+    }
+    return nil
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "slices"
+    "testing"
+    "time"
+)
+
+// env returns a lookupEnv over vars.
+func env(vars map[string]string) func(string) (string, bool) {
+    return func(k string) (string, bool) {
+        v, ok := vars[k]
+        return v, ok
+    }
+}
+
+func TestLoadPrecedence(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "slideflow.toml")
+    err := os.WriteFile(path, []byte(`
+[server]
+port = 1000
+http2 = false
+shutdown_timeout = "30s"
+
+[log]
+level = "warn"
+format = "text"
+
+[moderation]
+ng_words = ["file"]
+trusted_proxies = ["10.0.0.0/8"]
+`), 0o644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    c, err := Load([]string{"-port", "3000", "-http2", "-ng-words", "a,b"}, env(map[string]string{
+        FileEnv:           path,
+        "PORT":            "2000",
+        "LOG_LEVEL":       "debug",
+        "LOG_FORMAT":      "", // empty, so ignored
+        "TRUSTED_PROXIES": "", // empty, but this one may be cleared
+    }))
+    if err != nil {
+        t.Fatal(err)
+    }
+    checks := []struct {
+        name      string
+        got, want any
+    }{
+        {"flag over env and file", c.Port, 3000},
+        {"bare bool flag over file", c.HTTP2, true},
+        {"list flag over file", c.NGWords, []string{"a", "b"}},
+        {"env over file", c.LogLevel, "debug"},
+        {"empty env ignored", c.LogFormat, "text"},
+        {"empty env clears", c.TrustedProxies, []string{}},
+        {"file over default", c.ShutdownTimeout, 30 * time.Second},
+        {"default", c.RoomIDLength, Default().RoomIDLength},
+    }
+    for _, ck := range checks {
+        if !equal(ck.got, ck.want) {
+            t.Errorf("%s: got %v, want %v", ck.name, ck.got, ck.want)
+        }
+    }
+
+    // -config names the file over CONFIG_FILE
+    other := filepath.Join(t.TempDir(), "other.toml")
+    os.WriteFile(other, []byte("[server]\nport = 4000\n"), 0o644)
+    c, err = Load([]string{"-config", other}, env(map[string]string{FileEnv: path}))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if c.Port != 4000 || !c.HTTP2 {
+        t.Errorf("-config over CONFIG_FILE: port %d, http2 %v", c.Port, c.HTTP2)
+    }
+}
+
+func equal(a, b any) bool {
+    if as, ok := a.([]string); ok {
+        bs, ok := b.([]string)
+        return ok && slices.Equal(as, bs)
+    }
+    return a == b
+}
+
+func TestLoadBoolFlag(t *testing.T) {
+    tests := []struct {
+        args []string
+        env  map[string]string
+        want bool
+    }{
+        {nil, nil, true},
+        {[]string{"-http2=false"}, nil, false},
+        {nil, map[string]string{"HTTP2": "false"}, false},
+        {[]string{"-http2"}, map[string]string{"HTTP2": "false"}, true},
+        {[]string{"-http2=false", "-port", "9000"}, map[string]string{"HTTP2": "true"}, false},
+    }
+    for _, tt := range tests {
+        c, err := Load(tt.args, env(tt.env))
+        if err != nil {
+            t.Errorf("%v %v: %v", tt.args, tt.env, err)
+            continue
+        }
+        if c.HTTP2 != tt.want {
+            t.Errorf("%v %v: http2 %v, want %v", tt.args, tt.env, c.HTTP2, tt.want)
+        }
+    }
+    if _, err := Load([]string{"-http2=maybe"}, env(nil)); err == nil {
+        t.Error("-http2=maybe accepted")
+    }
+    if _, err := Load([]string{"-port"}, env(nil)); err == nil {
+        t.Error("-port without a value accepted")
+    }
+}
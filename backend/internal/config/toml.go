@@ -0,0 +1,313 @@
+package config
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// value is one setting as written in the config file: a single value or
+// an array of them, both kept as text for the setting to parse.
+type value struct {
+    line int
+    list bool
+    vals []string
+}
+
+// parseFile reads the subset of TOML the config file needs: [tables],
+// key = value pairs, # comments, and values that are strings ("basic" or
+// 'literal'), integers, booleans or arrays of those, which may span lines.
+// Keys come back as "table.key".
+func parseFile(data []byte) (map[string]value, error) {
+    out := map[string]value{}
+    table := ""
+    tables := map[string]bool{}
+    lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+    for i := 0; i < len(lines); i++ {
+        n := i + 1
+        line, err := stripComment(lines[i])
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %w", n, err)
+        }
+        line = strings.TrimSpace(line)
+        if line == "" {
+            continue
+        }
+        if strings.HasPrefix(line, "[") {
+            if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+                return nil, fmt.Errorf("line %d: invalid table header", n)
+            }
+            table = strings.TrimSpace(line[1 : len(line)-1])
+            if !validKey(table) {
+                return nil, fmt.Errorf("line %d: invalid table name %q", n, table)
+            }
+            if tables[table] {
+                return nil, fmt.Errorf("line %d: table [%s] defined twice", n, table)
+            }
+            tables[table] = true
+            continue
+        }
+        key, raw, ok := strings.Cut(line, "=")
+        if !ok {
+            return nil, fmt.Errorf("line %d: want key = value", n)
+        }
+        key = strings.TrimSpace(key)
+        if !validKey(key) {
+            return nil, fmt.Errorf("line %d: invalid key %q", n, key)
+        }
+        raw = strings.TrimSpace(raw)
+        // an array carries on until its closing bracket
+        for strings.HasPrefix(raw, "[") && !closed(raw) && i+1 < len(lines) {
+            i++
+            next, err := stripComment(lines[i])
+            if err != nil {
+                return nil, fmt.Errorf("line %d: %w", i+1, err)
+            }
+            raw += "\n" + next
+        }
+        v, err := parseValue(raw)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %s: %w", n, key, err)
+        }
+        v.line = n
+        if table != "" {
+            key = table + "." + key
+        }
+        if _, dup := out[key]; dup {
+            return nil, fmt.Errorf("line %d: %s set twice", n, key)
+        }
+        out[key] = v
+    }
+    return out, nil
+}
+
+func validKey(k string) bool {
+    if k == "" {
+        return false
+    }
+    for _, r := range k {
+        if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+            return false
+        }
+    }
+    return true
+}
+
+// stripComment drops a trailing # comment, leaving # inside strings.
+func stripComment(line string) (string, error) {
+    var quote byte
+    for i := 0; i < len(line); i++ {
+        c := line[i]
+        switch {
+        case quote == '"' && c == '\\':
+            i++
+        case quote != 0 && c == quote:
+            quote = 0
+        case quote != 0:
+        case c == '"' || c == '\'':
+            quote = c
+        case c == '#':
+            return line[:i], nil
+        }
+    }
+    if quote != 0 {
+        return "", fmt.Errorf("unterminated string")
+    }
+    return line, nil
+}
+
+// closed reports whether raw holds as many ] as [ outside strings.
+func closed(raw string) bool {
+    depth := 0
+    var quote byte
+    for i := 0; i < len(raw); i++ {
+        c := raw[i]
+        switch {
+        case quote == '"' && c == '\\':
+            i++
+        case quote != 0 && c == quote:
+            quote = 0
+        case quote != 0:
+        case c == '"' || c == '\'':
+            quote = c
+        case c == '[':
+            depth++
+        case c == ']':
+            depth--
+        }
+    }
+    return depth <= 0
+}
+
+func parseValue(raw string) (value, error) {
+    if !strings.HasPrefix(raw, "[") {
+        s, rest, err := parseScalar(raw)
+        if err != nil {
+            return value{}, err
+        }
+        if strings.TrimSpace(rest) != "" {
+            return value{}, fmt.Errorf("unexpected %q after value", strings.TrimSpace(rest))
+        }
+        return value{vals: []string{s}}, nil
+    }
+    v := value{list: true, vals: []string{}}
+    rest := strings.TrimSpace(raw[1:])
+    for {
+        if strings.HasPrefix(rest, "]") {
+            break
+        }
+        if rest == "" {
+            return value{}, fmt.Errorf("unterminated array")
+        }
+        if strings.HasPrefix(rest, "[") {
+            return value{}, fmt.Errorf("nested arrays are not supported")
+        }
+        s, r, err := parseScalar(rest)
+        if err != nil {
+            return value{}, err
+        }
+        v.vals = append(v.vals, s)
+        rest = strings.TrimSpace(r)
+        if rest == "" {
+            return value{}, fmt.Errorf("unterminated array")
+        }
+        if strings.HasPrefix(rest, ",") {
+            rest = strings.TrimSpace(rest[1:])
+        } else if !strings.HasPrefix(rest, "]") {
+            return value{}, fmt.Errorf("want , or ] in array")
+        }
+    }
+    if extra := strings.TrimSpace(rest[1:]); extra != "" {
+        return value{}, fmt.Errorf("unexpected %q after array", extra)
+    }
+    return v, nil
+}
+
+// parseScalar reads one value from the start of s and returns it as text
+// along with whatever follows it.
+func parseScalar(s string) (string, string, error) {
+    if s == "" {
+        return "", "", fmt.Errorf("missing value")
+    }
+    switch s[0] {
+    case '"':
+        for i := 1; i < len(s); i++ {
+            switch s[i] {
+            case '\\':
+                i++
+            case '"':
+                v, err := unescape(s[1:i])
+                if err != nil {
+                    return "", "", fmt.Errorf("invalid string %s: %w", s[:i+1], err)
+                }
+                return v, s[i+1:], nil
+            case '\n':
+                return "", "", fmt.Errorf("unterminated string")
+            }
+        }
+        return "", "", fmt.Errorf("unterminated string")
+    case '\'':
+        end := strings.IndexAny(s[1:], "'\n")
+        if end < 0 || s[1+end] != '\'' {
+            return "", "", fmt.Errorf("unterminated string")
+        }
+        v := s[1 : 1+end]
+        if i := strings.IndexFunc(v, isControl); i >= 0 {
+            return "", "", fmt.Errorf("invalid string %s: control character %U", s[:2+end], rune(v[i]))
+        }
+        return v, s[2+end:], nil
+    }
+    end := strings.IndexAny(s, ",]\n")
+    if end < 0 {
+        end = len(s)
+    }
+    bare := strings.TrimSpace(s[:end])
+    switch {
+    case bare == "true" || bare == "false":
+    case isInt(bare):
+        bare = strings.ReplaceAll(bare, "_", "")
+    default:
+        return "", "", fmt.Errorf("invalid value %q (strings need quotes)", bare)
+    }
+    return bare, s[end:], nil
+}
+
+// isInt reports whether s is a decimal TOML integer: no leading zeros,
+// and underscores only between digits.
+func isInt(s string) bool {
+    if s != "" && (s[0] == '+' || s[0] == '-') {
+        s = s[1:]
+    }
+    if s == "" || len(s) > 1 && s[0] == '0' {
+        return false
+    }
+    for i := 0; i < len(s); i++ {
+        switch {
+        case s[i] >= '0' && s[i] <= '9':
+        case s[i] == '_' && i > 0 && i < len(s)-1 && s[i-1] != '_':
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// unescape decodes the body of a basic string. TOML allows only \b \t
+// \n \f \r \" \\ and \uXXXX or \UXXXXXXXX naming a Unicode scalar
+// value; other escapes, and control characters other than tab, are errors.
+func unescape(s string) (string, error) {
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        if c != '\\' {
+            if isControl(rune(c)) {
+                return "", fmt.Errorf("control character %U", rune(c))
+            }
+            b.WriteByte(c)
+            continue
+        }
+        i++
+        if i == len(s) {
+            return "", fmt.Errorf("lone backslash")
+        }
+        switch e := s[i]; e {
+        case 'b':
+            b.WriteByte('\b')
+        case 't':
+            b.WriteByte('\t')
+        case 'n':
+            b.WriteByte('\n')
+        case 'f':
+            b.WriteByte('\f')
+        case 'r':
+            b.WriteByte('\r')
+        case '"', '\\':
+            b.WriteByte(e)
+        case 'u', 'U':
+            n := 4
+            if e == 'U' {
+                n = 8
+            }
+            if i+n >= len(s) {
+                return "", fmt.Errorf("short \\%c escape", e)
+            }
+            hex := s[i+1 : i+1+n]
+            code, err := strconv.ParseUint(hex, 16, 32)
+            if err != nil || !utf8.ValidRune(rune(code)) {
+                return "", fmt.Errorf("invalid escape \\%c%s", e, hex)
+            }
+            b.WriteRune(rune(code))
+            i += n
+        default:
+            return "", fmt.Errorf("invalid escape \\%c", e)
+        }
+    }
+    return b.String(), nil
+}
+
+// isControl reports whether r is a control character TOML doesn't allow
+// in a string as is.
+func isControl(r rune) bool {
+    return r < 0x20 && r != '\t' || r == 0x7f
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParseFile(t *testing.T) {
+    tests := []struct {
+        name string
+        in   string
+        want map[string]value
+    }{
+        {
+            name: "tables, scalars and comments",
+            in: `port = 8080 # trailing
+# a comment line
+[rooms]
+ttl = "24h"
+id_length = 1_000
+enabled = true
+path = 'C:\data\rooms.json'
+`,
+            want: map[string]value{
+                "port":            {line: 1, vals: []string{"8080"}},
+                "rooms.ttl":       {line: 4, vals: []string{"24h"}},
+                "rooms.id_length": {line: 5, vals: []string{"1000"}},
+                "rooms.enabled":   {line: 6, vals: []string{"true"}},
+                "rooms.path":      {line: 7, vals: []string{`C:\data\rooms.json`}},
+            },
+        },
+        {
+            name: "escapes",
+            in:   `s = "tab\there \"q\" back\\slash \u00e9\U0001F600 \b\f\r\n"`,
+            want: map[string]value{
+                "s": {line: 1, vals: []string{"tab\there \"q\" back\\slash é😀 \b\f\r\n"}},
+            },
+        },
+        {
+            name: "# and ] inside strings",
+            in: `[moderation]
+ng_words = ["a # b", 'c]d', "e\"]#"] # ] "
+`,
+            want: map[string]value{
+                "moderation.ng_words": {line: 2, list: true, vals: []string{"a # b", "c]d", "e\"]#"}},
+            },
+        },
+        {
+            name: "multi-line array",
+            in: `ng_words = [ # the list
+    "x]y",     # one ]
+    'p#q',
+    "[z",
+    # "not a value",
+]
+after = 1
+`,
+            want: map[string]value{
+                "ng_words": {line: 1, list: true, vals: []string{"x]y", "p#q", "[z"}},
+                "after":    {line: 7, vals: []string{"1"}},
+            },
+        },
+        {
+            name: "empty array and signed integers",
+            in:   "a = []\nb = -5\nc = +0\n",
+            want: map[string]value{
+                "a": {line: 1, list: true, vals: []string{}},
+                "b": {line: 2, vals: []string{"-5"}},
+                "c": {line: 3, vals: []string{"+0"}},
+            },
+        },
+        {
+            name: "CRLF line endings",
+            in:   "[hub]\r\nsend_buffer = 64\r\n",
+            want: map[string]value{
+                "hub.send_buffer": {line: 2, vals: []string{"64"}},
+            },
+        },
+    }
+    for _, tt := range tests {
+        got, err := parseFile([]byte(tt.in))
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestParseFileErrors(t *testing.T) {
+    tests := []struct {
+        in, err string
+    }{
+        {`s = "\x41"`, `invalid escape \x`},
+        {`s = "\a"`, `invalid escape \a`},
+        {`s = "\'"`, `invalid escape \'`},
+        {`s = "\u12"`, `short \u escape`},
+        {`s = "\uD800"`, `invalid escape \uD800`},
+        {`s = "\U00110000"`, `invalid escape \U00110000`},
+        {`s = "\uzzzz"`, `invalid escape \uzzzz`},
+        {"s = \"a\x01b\"", "control character"},
+        {"s = 'a\x7fb'", "control character"},
+        {`s = "open`, "unterminated string"},
+        {`s = 'open`, "unterminated string"},
+        {"a = [\n\"x\",\n", "unterminated array"},
+        {"a = [\"x]\"", "unterminated array"},
+        {`a = ["x" "y"]`, "want , or ]"},
+        {`a = [[1]]`, "nested arrays"},
+        {`a = [1] 2`, "after array"},
+        {`a = "x" y`, "after value"},
+        {`port =`, "missing value"},
+        {"port =   # none", "missing value"},
+        {`a = bare`, "strings need quotes"},
+        {`a = 012`, "strings need quotes"},
+        {`a = 1__0`, "strings need quotes"},
+        {`a = 1_`, "strings need quotes"},
+        {`a = 1.5`, "strings need quotes"},
+        {`a`, "want key = value"},
+        {`a.b = 1`, "invalid key"},
+        {`[a`, "invalid table header"},
+        {`[[a]]`, "invalid table header"},
+        {"[a]\n[a]", "defined twice"},
+        {"a = 1\na = 2", "set twice"},
+    }
+    for _, tt := range tests {
+        _, err := parseFile([]byte(tt.in))
+        if err == nil {
+            t.Errorf("%q: no error, want %q", tt.in, tt.err)
+        } else if !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("%q: error %q, want %q", tt.in, err, tt.err)
+        }
+    }
+}
@@ -0,0 +1,213 @@
+// Package event defines the JSON events the server sends to room clients
+// (overlays, the presenter page, admin sockets). schema.json describes the
+// same shapes for clients that want to validate what they receive.
+package event
+
+import (
+    _ "embed"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "slideflow/internal/util"
+)
+
+// Version is bumped on incompatible changes to any event shape.
+const Version = 1
+
+type Type string
+
+const (
+    TypeChat    Type = "chat"
+    TypeClear   Type = "clear"
+    TypeRetract Type = "retract"
+    TypePause   Type = "pause"
+    TypeConfig  Type = "config"
+    TypeSystem  Type = "system"
+    // Sent to admin sockets only.
+    TypePending Type = "pending"
+    TypeReview  Type = "review"
+    // Replies to frames sent by a client on its own socket.
+    TypeAck   Type = "ack"
+    TypeError Type = "error"
+)
+
+// Schema is the JSON Schema (draft 2020-12) for every event.
+//
+//go:embed schema.json
+var Schema []byte
+
+// Header is common to every event.
+type Header struct {
+    V      int    `json:"v"`
+    Type   Type   `json:"type"`
+    ID     string `json:"id"`
+    RoomID string `json:"roomId"`
+    // TS is the server time the event was created, in Unix milliseconds.
+    TS int64 `json:"ts"`
+    // Seq orders replayable events within a room; zero (omitted) for the rest.
+    Seq uint64 `json:"seq,omitempty"`
+    // Epoch comes with Seq and changes whenever the room's seqs start over,
+    // e.g. after a restart. Clients resume with ?since=<epoch>:<seq>.
+    Epoch string `json:"epoch,omitempty"`
+}
+
+// EventHeader gives access to the common fields of any event.
+func (h *Header) EventHeader() *Header { return h }
+
+// Event is implemented by every event type via its embedded Header.
+type Event interface {
+    EventHeader() *Header
+}
+
+func newHeader(t Type, roomID string) Header {
+    return Header{
+        V:      Version,
+        Type:   t,
+        ID:     util.NewRoomID(12),
+        RoomID: roomID,
+        TS:     time.Now().UnixMilli(),
+    }
+}
+
+// Chat is a comment to display. Style fields are omitted for the defaults
+// (white, medium, scrolling).
+type Chat struct {
+    Header
+    Text   string `json:"text"`
+    Handle string `json:"handle,omitempty"`
+    // Color is a hex value such as "#ff0000".
+    Color string `json:"color,omitempty"`
+    // Size is "small", "medium" or "big".
+    Size string `json:"size,omitempty"`
+    // Position is "naka" (scroll), "ue" (fixed top) or "shita" (fixed bottom).
+    Position string `json:"position,omitempty"`
+}
+
+func NewChat(roomID, text, handle string) *Chat {
+    return &Chat{Header: newHeader(TypeChat, roomID), Text: text, Handle: handle}
+}
+
+// Clear removes every comment currently on screen.
+type Clear struct {
+    Header
+}
+
+func NewClear(roomID string) *Clear {
+    return &Clear{Header: newHeader(TypeClear, roomID)}
+}
+
+// Retract removes a single chat event, by its ID, from screens and from
+// what reconnecting clients are replayed.
+type Retract struct {
+    Header
+    MsgID string `json:"msgId"`
+}
+
+func NewRetract(roomID, msgID string) *Retract {
+    return &Retract{Header: newHeader(TypeRetract, roomID), MsgID: msgID}
+}
+
+// Pause reports that posting was paused or resumed.
+type Pause struct {
+    Header
+    Paused bool `json:"paused"`
+}
+
+func NewPause(roomID string, paused bool) *Pause {
+    return &Pause{Header: newHeader(TypePause, roomID), Paused: paused}
+}
+
+// Config reports the room's settings after a change.
+type Config struct {
+    Header
+    SlowModeMs     int64    `json:"slowModeMs"`
+    MaxTextLen     int      `json:"maxTextLen"`
+    MaxHandleLen   int      `json:"maxHandleLen"`
+    AllowAnonymous bool     `json:"allowAnonymous"`
+    CooldownMs     int64    `json:"cooldownMs"`
+    AllowedStyles  []string `json:"allowedStyles"`
+    // ReviewMode means comments are shown only after a moderator approves
+    // them.
+    ReviewMode bool `json:"reviewMode,omitempty"`
+}
+
+func NewConfig(roomID string) *Config {
+    return &Config{Header: newHeader(TypeConfig, roomID)}
+}
+
+// System is a notice from the server rather than from a participant.
+type System struct {
+    Header
+    Level string `json:"level"` // "info" or "warn"
+    Code  string `json:"code,omitempty"`
+    Text  string `json:"text"`
+}
+
+func NewSystem(roomID, level, code, text string) *System {
+    return &System{Header: newHeader(TypeSystem, roomID), Level: level, Code: code, Text: text}
+}
+
+// Pending announces a comment held for review. Only admin sockets get it.
+type Pending struct {
+    Header
+    Chat   *Chat  `json:"chat"`
+    Reason string `json:"reason"`
+}
+
+func NewPending(roomID string, chat *Chat, reason string) *Pending {
+    return &Pending{Header: newHeader(TypePending, roomID), Chat: chat, Reason: reason}
+}
+
+// Review reports a moderator's decision on a held comment, so every admin
+// socket can drop it from its queue. Text is the comment as approved.
+type Review struct {
+    Header
+    MsgID    string `json:"msgId"`
+    Approved bool   `json:"approved"`
+    Text     string `json:"text,omitempty"`
+}
+
+func NewReview(roomID, msgID string, approved bool) *Review {
+    return &Review{Header: newHeader(TypeReview, roomID), MsgID: msgID, Approved: approved}
+}
+
+// Ack acknowledges a frame a client sent, matched by Ref. For chat frames
+// Action says what moderation did with it ("accepted", "masked", "held")
+// and Text is the comment as shown.
+type Ack struct {
+    Header
+    Ref    string `json:"ref,omitempty"`
+    OK     bool   `json:"ok"`
+    Action string `json:"action,omitempty"`
+    Text   string `json:"text,omitempty"`
+}
+
+func NewAck(roomID, ref string) *Ack {
+    return &Ack{Header: newHeader(TypeAck, roomID), Ref: ref, OK: true}
+}
+
+// Error rejects a frame a client sent. Status mirrors the HTTP status the
+// same request would have got over HTTP.
+type Error struct {
+    Header
+    Ref    string `json:"ref,omitempty"`
+    Status int    `json:"status"`
+    Error  string `json:"error"`
+    // RetryAfterMs is set on rate-limit errors: how long to wait.
+    RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+}
+
+func NewError(roomID, ref string, status int, msg string) *Error {
+    return &Error{Header: newHeader(TypeError, roomID), Ref: ref, Status: status, Error: msg}
+}
+
+// Encode is the one place events are serialised.
+func Encode(e Event) []byte {
+    b, err := json.Marshal(e)
+    if err != nil {
+        // Only plain strings and numbers above; this can't happen.
+        panic(fmt.Sprintf("event: encode %s: %v", e.EventHeader().Type, err))
+    }
+    return b
+}
@@ -1,80 +1,558 @@
 package hub
 
 import (
+    "errors"
+    "slices"
+    "sync"
+    "sync/atomic"
     "time"
 
     "github.com/gorilla/websocket"
 )
 
+// CloseRoomClosed is the WebSocket close code sent when a room is closed by
+// its presenter or expires. Clients should not reconnect after receiving it.
+const CloseRoomClosed = 4000
+
+// CloseTooSlow is sent to a client disconnected by PolicyDisconnect. It may
+// reconnect and resume.
+const CloseTooSlow = 4001
+
+// HistorySize is how many sequenced frames a hub keeps for replay.
+const HistorySize = 200
+
+// DefaultSendBuffer is how many frames a client may have queued.
+const DefaultSendBuffer = 256
+
+var (
+    // ErrBusy is returned when the hub can't take another frame right now.
+    ErrBusy = errors.New("hub: busy")
+    // ErrClosed is returned for frames sent after Stop.
+    ErrClosed = errors.New("hub: closed")
+)
+
+// Config tunes a hub. The zero value uses PolicyDropOldest and
+// DefaultSendBuffer, and serves a single replica.
+type Config struct {
+    Policy     Policy
+    SendBuffer int
+    // Peers, if set, receives every locally published frame and numbers
+    // chat frames; see Receive for the other direction.
+    Peers Peers
+    // Epoch names the run of seq numbers the hub hands out. Clients
+    // resume with it so a since from an earlier run, whose seqs started
+    // over, isn't mistaken for a position in this one.
+    Epoch string
+    // OnFanout, if set, is called from Run with how long each broadcast
+    // frame waited between being accepted and being queued for every
+    // client. It must be quick.
+    OnFanout func(time.Duration)
+}
+
 // Hub manages WebSocket clients and broadcasts
 type Hub struct {
     clients    map[*Client]bool
-    broadcast  chan []byte
+    broadcast  chan frame
     register   chan *Client
     unregister chan *Client
+    direct     chan directFrame
+    stats      chan chan []ClientStats
+    stop       chan closeFrame
+    done       chan struct{}
+    stopOnce   sync.Once
+    nclients   atomic.Int64
+
+    // writers counts WebSocket clients whose writePump is still running
+    writersMu   sync.Mutex
+    writersDone *sync.Cond
+    writers     int
+
+    policy     Policy
+    sendBuffer int
+    peers      Peers
+    onFanout   func(time.Duration)
+    epoch      string
+    nextID     atomic.Uint64
+
+    // counters for Stats
+    dropped      atomic.Uint64
+    mostDropped  atomic.Uint64 // written by Run only
+    disconnected atomic.Uint64
+    busy         atomic.Uint64
+
+    // seqMu orders Publish calls so frames enter broadcast in seq order
+    seqMu sync.Mutex
+    seq   uint64
+
+    // history and lastSeq are owned by Run
+    history []frame
+    lastSeq uint64
+}
+
+// frame is a message on its way to clients. seq is zero for frames that are
+// not kept for replay; admin frames only go to admin clients and are never
+// kept. key names a kept frame so a later frame with retract set to the
+// same key removes it from history. Frames with the same coalesce key
+// supersede each other under PolicyCoalesce. at is when the hub accepted
+// it.
+type frame struct {
+    seq      uint64
+    data     []byte
+    meta     []byte // for Peers only
+    admin    bool
+    key      string
+    retract  string
+    coalesce string
+    at       time.Time
 }
 
-func NewHub() *Hub {
-    return &Hub{
+// directFrame is a message for a single client, e.g. a reply to its input.
+type directFrame struct {
+    c    *Client
+    data []byte
+}
+
+type closeFrame struct {
+    code   int
+    reason string
+}
+
+func NewHub(cfg Config) *Hub {
+    if cfg.Policy == "" {
+        cfg.Policy = PolicyDropOldest
+    }
+    if cfg.SendBuffer <= 0 {
+        cfg.SendBuffer = DefaultSendBuffer
+    }
+    h := &Hub{
         clients:    make(map[*Client]bool),
-        broadcast:  make(chan []byte, 256),
+        broadcast:  make(chan frame, 256),
         register:   make(chan *Client),
         unregister: make(chan *Client),
+        direct:     make(chan directFrame, 16),
+        stats:      make(chan chan []ClientStats),
+        stop:       make(chan closeFrame, 1),
+        done:       make(chan struct{}),
+        policy:     cfg.Policy,
+        sendBuffer: cfg.SendBuffer,
+        peers:      cfg.Peers,
+        onFanout:   cfg.OnFanout,
+        epoch:      cfg.Epoch,
     }
+    h.writersDone = sync.NewCond(&h.writersMu)
+    return h
 }
 
 func (h *Hub) Run() {
+    defer close(h.done)
     for {
         select {
+        case cf := <-h.stop:
+            // Deliver what was accepted before Stop, then tell every
+            // client why before hanging up; writePump sends the close
+            // frame once it has flushed the queue.
+            for len(h.broadcast) > 0 {
+                h.fanout(<-h.broadcast)
+            }
+            for c := range h.clients {
+                c.queue.shut(cf)
+                delete(h.clients, c)
+            }
+            h.nclients.Store(0)
+            return
         case c := <-h.register:
             h.clients[c] = true
+            h.nclients.Store(int64(len(h.clients)))
+            if c.resume {
+                h.replay(c)
+            }
         case c := <-h.unregister:
             if _, ok := h.clients[c]; ok {
                 delete(h.clients, c)
-                close(c.send)
+                c.queue.shut(closeFrame{})
             }
-        case msg := <-h.broadcast:
+            h.nclients.Store(int64(len(h.clients)))
+        case d := <-h.direct:
+            if _, ok := h.clients[d.c]; ok {
+                h.deliver(d.c, frame{data: d.data})
+            }
+        case reply := <-h.stats:
+            out := make([]ClientStats, 0, len(h.clients))
             for c := range h.clients {
-                select {
-                case c.send <- msg:
-                default:
-                    close(c.send)
-                    delete(h.clients, c)
-                }
+                out = append(out, c.stats())
             }
+            reply <- out
+        case f := <-h.broadcast:
+            h.fanout(f)
+        }
+    }
+}
+
+// fanout records f for replay as needed and queues it for its clients.
+// Run only.
+func (h *Hub) fanout(f frame) {
+    if f.seq > 0 {
+        h.remember(f)
+    }
+    if f.retract != "" {
+        h.forget(f.retract)
+    }
+    for c := range h.clients {
+        if f.admin && !c.admin {
+            continue
+        }
+        h.deliver(c, f)
+    }
+    h.nclients.Store(int64(len(h.clients)))
+    if h.onFanout != nil {
+        h.onFanout(time.Since(f.at))
+    }
+}
+
+// deliver queues f for c under the hub's backpressure policy. Run only.
+func (h *Hub) deliver(c *Client, f frame) {
+    dropped, ok := c.queue.push(f, h.policy)
+    if !ok {
+        c.queue.shut(closeFrame{code: CloseTooSlow, reason: "too slow"})
+        delete(h.clients, c)
+        h.disconnected.Add(1)
+        return
+    }
+    if dropped > 0 {
+        n := c.dropped.Add(uint64(dropped))
+        h.dropped.Add(uint64(dropped))
+        if n > h.mostDropped.Load() {
+            h.mostDropped.Store(n)
         }
     }
 }
 
-func (h *Hub) Broadcast(b []byte) {
-    h.broadcast <- b
+// remember adds f to the bounded replay history, kept in seq order.
+// Frames from other replicas can arrive slightly out of order.
+func (h *Hub) remember(f frame) {
+    i := len(h.history)
+    for i > 0 && h.history[i-1].seq > f.seq {
+        i--
+    }
+    if len(h.history) == HistorySize {
+        if i == 0 {
+            return // older than anything we keep
+        }
+        copy(h.history, h.history[1:i])
+        h.history[i-1] = f
+    } else {
+        h.history = slices.Insert(h.history, i, f)
+    }
+    h.lastSeq = max(h.lastSeq, f.seq)
 }
 
-func (h *Hub) RegisterClient(c *Client) { h.register <- c }
-func (h *Hub) UnregisterClient(c *Client) { h.unregister <- c }
+// forget drops the remembered frame with the given key, if any, so it is
+// not replayed to clients that connect later.
+func (h *Hub) forget(key string) {
+    h.history = slices.DeleteFunc(h.history, func(f frame) bool { return f.key == key })
+}
 
-// Client wraps a websocket connection for the Hub
+// replay queues every remembered frame after c.since, ahead of any live
+// frame. A client resuming from another epoch saw a previous run of the
+// room's seqs, so it gets everything we have; so does one that gave no
+// epoch and a since beyond our last seq.
+func (h *Hub) replay(c *Client) {
+    since := c.since
+    if c.epoch != "" && c.epoch != h.epoch || c.epoch == "" && since > h.lastSeq {
+        since = 0
+    }
+    for _, f := range h.history {
+        if f.seq <= since {
+            continue
+        }
+        h.deliver(c, f)
+        if !h.clients[c] {
+            return
+        }
+    }
+}
+
+// Stop disconnects every client with the given close code and reason and
+// ends Run. It is safe to call more than once; later calls are no-ops.
+func (h *Hub) Stop(code int, reason string) {
+    h.stopOnce.Do(func() {
+        h.stop <- closeFrame{code: code, reason: reason}
+    })
+}
+
+// Done is closed once Run has returned.
+func (h *Hub) Done() <-chan struct{} { return h.done }
+
+// Wait blocks until Run has returned and every WebSocket client has
+// written its last frames and close frame, or given up trying.
+func (h *Hub) Wait() {
+    <-h.done
+    h.writersMu.Lock()
+    for h.writers > 0 {
+        h.writersDone.Wait()
+    }
+    h.writersMu.Unlock()
+}
+
+// Epoch returns the hub's Config.Epoch.
+func (h *Hub) Epoch() string { return h.epoch }
+
+// ClientCount reports the number of connected clients.
+func (h *Hub) ClientCount() int { return int(h.nclients.Load()) }
+
+// enqueue hands f to Run without blocking the caller.
+func (h *Hub) enqueue(f frame) error {
+    select {
+    case <-h.done:
+        return ErrClosed
+    default:
+    }
+    f.at = time.Now()
+    select {
+    case h.broadcast <- f:
+        return nil
+    case <-h.done:
+        return ErrClosed
+    default:
+        h.busy.Add(1)
+        return ErrBusy
+    }
+}
+
+// send queues a locally published frame and forwards it to the peers.
+func (h *Hub) send(f frame) error {
+    if err := h.enqueue(f); err != nil {
+        return err
+    }
+    if h.peers != nil {
+        h.peers.Forward(f.export())
+    }
+    return nil
+}
+
+// Broadcast queues b for every client without recording it for replay.
+// It never blocks: it fails with ErrBusy if the hub is backed up and with
+// ErrClosed after Stop.
+func (h *Hub) Broadcast(b []byte) error {
+    return h.send(frame{data: b})
+}
+
+// BroadcastLatest is Broadcast for state a client only needs the newest
+// of, such as the room's settings; key groups frames that supersede each
+// other under PolicyCoalesce.
+func (h *Hub) BroadcastLatest(key string, b []byte) error {
+    return h.send(frame{data: b, coalesce: key})
+}
+
+// BroadcastAdmin queues b for admin clients only, see Client.SetAdmin.
+func (h *Hub) BroadcastAdmin(b []byte) error {
+    return h.send(frame{data: b, admin: true})
+}
+
+// Publish assigns the next sequence number, lets build render the frame
+// with it, and queues the result for every client and for replay. key
+// identifies the frame for Retract and may be empty; meta, if any, goes
+// to Peers with the frame as Frame.Meta. Like Broadcast it doesn't wait
+// for the hub; a refused frame gives its seq back, unless the seq came
+// from Peers, where it leaves a gap.
+func (h *Hub) Publish(key string, meta []byte, build func(seq uint64) []byte) error {
+    h.seqMu.Lock()
+    defer h.seqMu.Unlock()
+    seq := h.seq + 1
+    if h.peers != nil {
+        var err error
+        if seq, err = h.peers.NextSeq(); err != nil {
+            return err
+        }
+    }
+    if err := h.send(frame{seq: seq, data: build(seq), meta: meta, key: key}); err != nil {
+        return err
+    }
+    h.seq = seq
+    return nil
+}
+
+// Retract queues b for every client and removes the published frame with
+// the given key from the replay history.
+func (h *Hub) Retract(key string, b []byte) error {
+    return h.send(frame{data: b, retract: key})
+}
+
+// Counters are a hub's running totals since it started: Dropped counts
+// frames discarded for slow clients, MostDropped the most discarded for
+// any one client, Disconnected the clients closed for being too slow, and
+// Busy the broadcasts refused because the hub itself was backed up.
+type Counters struct {
+    Dropped      uint64 `json:"dropped"`
+    MostDropped  uint64 `json:"mostDropped"`
+    Disconnected uint64 `json:"disconnected"`
+    Busy         uint64 `json:"busy"`
+}
+
+// Stats describes how a hub is coping with its clients.
+type Stats struct {
+    Policy  Policy `json:"policy"`
+    Clients int    `json:"clients"`
+    Counters
+    PerClient []ClientStats `json:"perClient"`
+}
+
+// ClientStats describes one connected client.
+type ClientStats struct {
+    ID      uint64 `json:"id"`
+    Admin   bool   `json:"admin"`
+    Queued  int    `json:"queued"`
+    Dropped uint64 `json:"dropped"`
+}
+
+// Counters returns the hub's totals. Unlike Stats it doesn't wait on the
+// hub, so it stays cheap however busy the room is.
+func (h *Hub) Counters() Counters {
+    return Counters{
+        Dropped:      h.dropped.Load(),
+        MostDropped:  h.mostDropped.Load(),
+        Disconnected: h.disconnected.Load(),
+        Busy:         h.busy.Load(),
+    }
+}
+
+// Stats returns the hub's counters and a snapshot of its clients, which
+// takes a turn of the hub's loop.
+func (h *Hub) Stats() Stats {
+    st := Stats{
+        Policy:    h.policy,
+        Counters:  h.Counters(),
+        PerClient: []ClientStats{},
+    }
+    reply := make(chan []ClientStats, 1)
+    select {
+    case h.stats <- reply:
+        st.PerClient = <-reply
+    case <-h.done:
+    }
+    st.Clients = len(st.PerClient)
+    return st
+}
+
+// RegisterClient adds c to the hub. If the hub has already stopped the
+// client is closed straight away.
+func (h *Hub) RegisterClient(c *Client) {
+    select {
+    case h.register <- c:
+    case <-h.done:
+        c.queue.shut(closeFrame{code: CloseRoomClosed, reason: "room closed"})
+    }
+}
+
+func (h *Hub) UnregisterClient(c *Client) {
+    select {
+    case h.unregister <- c:
+    case <-h.done:
+    }
+}
+
+// Client is one connection to the Hub: a WebSocket, or a stream its owner
+// writes out itself (see NewStream)
 type Client struct {
-    hub  *Hub
-    conn *websocket.Conn
-    send chan []byte
+    hub   *Hub
+    conn  *websocket.Conn // nil for streams
+    queue *queue
+    id    uint64
+    // onMessage handles frames the client sends; nil makes the client
+    // read-only and its frames are discarded.
+    onMessage func(c *Client, data []byte)
+    // resume/epoch/since request a replay of frames after since on
+    // register
+    resume bool
+    epoch  string
+    since  uint64
+    // admin clients also receive BroadcastAdmin frames
+    admin bool
+    // dropped counts frames discarded because the client fell behind
+    dropped atomic.Uint64
 }
 
 func NewClient(h *Hub, conn *websocket.Conn) *Client {
-    return &Client{hub: h, conn: conn, send: make(chan []byte, 256)}
+    return &Client{hub: h, conn: conn, queue: newQueue(h.sendBuffer), id: h.nextID.Add(1)}
+}
+
+// NewStream returns a client for a transport other than WebSocket, such as
+// a Server-Sent Events response. Instead of calling Start, the owner calls
+// Take whenever Ready fires, and UnregisterClient when its connection ends.
+func NewStream(h *Hub) *Client {
+    return &Client{hub: h, queue: newQueue(h.sendBuffer), id: h.nextID.Add(1)}
+}
+
+// Ready receives when frames are queued for the client or it is closed.
+func (c *Client) Ready() <-chan struct{} { return c.queue.ready }
+
+// Take returns the frames queued for the stream, in order, without
+// waiting. closed reports that the hub has let the client go, after the
+// last of its frames; code and reason then say why, as a WebSocket close
+// frame would (code is zero after UnregisterClient).
+func (c *Client) Take() (frames []Frame, closed bool, code int, reason string) {
+    fs, closed, cf := c.queue.take()
+    frames = make([]Frame, len(fs))
+    for i, f := range fs {
+        frames[i] = f.export()
+    }
+    return frames, closed, cf.code, cf.reason
+}
+
+func (c *Client) stats() ClientStats {
+    return ClientStats{ID: c.id, Admin: c.admin, Queued: c.queue.len(), Dropped: c.dropped.Load()}
+}
+
+// AcceptMessages lets the client send frames; each one is passed to fn on
+// the client's read goroutine. It must be called before Start.
+func (c *Client) AcceptMessages(fn func(c *Client, data []byte)) {
+    c.onMessage = fn
+}
+
+// SetAdmin marks the client as a moderator's socket, which also receives
+// frames sent with BroadcastAdmin. It must be called before RegisterClient.
+func (c *Client) SetAdmin() {
+    c.admin = true
+}
+
+// Reply sends data to this client only. It never blocks; the frame is
+// dropped if the client is gone or the hub is backed up.
+func (c *Client) Reply(data []byte) {
+    select {
+    case c.hub.direct <- directFrame{c: c, data: data}:
+    case <-c.hub.done:
+    default:
+    }
+}
+
+// ResumeFrom asks the hub to replay frames with seq > since before live
+// ones, or every remembered frame if epoch is not the hub's. An empty
+// epoch is trusted unless since is beyond the hub's last seq. It must be
+// called before RegisterClient.
+func (c *Client) ResumeFrom(epoch string, since uint64) {
+    c.resume = true
+    c.epoch = epoch
+    c.since = since
 }
 
 func (c *Client) Start() {
+    c.hub.writersMu.Lock()
+    c.hub.writers++
+    c.hub.writersMu.Unlock()
     go c.writePump()
     go c.readPump()
 }
 
 func (c *Client) readPump() {
     defer func() {
-        c.hub.unregister <- c
+        c.hub.UnregisterClient(c)
         c.conn.Close()
     }()
-    c.conn.SetReadLimit(1024)
+    if c.onMessage != nil {
+        c.conn.SetReadLimit(4096)
+    } else {
+        c.conn.SetReadLimit(1024)
+    }
     c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
     c.conn.SetPongHandler(func(string) error {
         c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -85,8 +563,11 @@ func (c *Client) readPump() {
         if err != nil {
             break
         }
-        // Relay any client message to the hub.
-        c.hub.broadcast <- message
+        // Viewers are read-only; anything else they send is ignored
+        // rather than relayed, so nothing bypasses moderation.
+        if c.onMessage != nil {
+            c.onMessage(c, message)
+        }
     }
 }
 
@@ -95,17 +576,29 @@ func (c *Client) writePump() {
     defer func() {
         ticker.Stop()
         c.conn.Close()
+        c.hub.writersMu.Lock()
+        c.hub.writers--
+        c.hub.writersMu.Unlock()
+        c.hub.writersDone.Broadcast()
     }()
     for {
         select {
-        case message, ok := <-c.send:
-            c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-            if !ok {
-                // Hub closed the channel.
-                c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-                return
+        case <-c.queue.ready:
+            frames, closed, cf := c.queue.take()
+            for _, f := range frames {
+                c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+                if err := c.conn.WriteMessage(websocket.TextMessage, f.data); err != nil {
+                    return
+                }
             }
-            if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+            if closed {
+                // Hub is done with us.
+                var payload []byte
+                if cf.code != 0 {
+                    payload = websocket.FormatCloseMessage(cf.code, cf.reason)
+                }
+                c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+                c.conn.WriteMessage(websocket.CloseMessage, payload)
                 return
             }
         case <-ticker.C:
@@ -0,0 +1,41 @@
+package hub
+
+import "encoding/json"
+
+// Peers links a hub to the hubs for the same room on other replicas.
+type Peers interface {
+    // Forward passes on a frame this hub has accepted from a local
+    // publisher. It must not block.
+    Forward(f Frame)
+    // NextSeq returns the room's next sequence number, shared by every
+    // replica so resumes work whichever one a client lands on.
+    NextSeq() (uint64, error)
+}
+
+// Frame is a broadcast frame as it travels between replicas. Direct
+// replies to a single client never leave the hub. Meta is the publisher's
+// note about the frame for the other replicas; clients never see it.
+type Frame struct {
+    Seq      uint64          `json:"seq,omitempty"`
+    Data     json.RawMessage `json:"data"`
+    Meta     json.RawMessage `json:"meta,omitempty"`
+    Admin    bool            `json:"admin,omitempty"`
+    Key      string          `json:"key,omitempty"`
+    Retract  string          `json:"retract,omitempty"`
+    Coalesce string          `json:"coalesce,omitempty"`
+}
+
+func (f frame) export() Frame {
+    return Frame{Seq: f.seq, Data: f.data, Meta: f.meta, Admin: f.admin, Key: f.key, Retract: f.retract, Coalesce: f.coalesce}
+}
+
+func (f Frame) frame() frame {
+    return frame{seq: f.Seq, data: f.Data, meta: f.Meta, admin: f.Admin, key: f.Key, retract: f.Retract, coalesce: f.Coalesce}
+}
+
+// Receive queues a frame without forwarding it to Peers: one that came
+// from another replica, or a notice for this replica's clients only. Like
+// Broadcast it never blocks.
+func (h *Hub) Receive(f Frame) error {
+    return h.enqueue(f.frame())
+}
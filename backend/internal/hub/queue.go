@@ -0,0 +1,114 @@
+package hub
+
+import (
+    "fmt"
+    "slices"
+    "sync"
+)
+
+// Policy says what happens when a client can't keep up and its send queue
+// is full.
+type Policy string
+
+const (
+    // PolicyDropOldest discards the client's oldest queued frame.
+    PolicyDropOldest Policy = "drop-oldest"
+    // PolicyCoalesce keeps only the newest of frames that supersede each
+    // other (config, pause) and, when full, sheds the oldest chat frame
+    // rather than a state change.
+    PolicyCoalesce Policy = "coalesce"
+    // PolicyDisconnect closes the client with CloseTooSlow; it reconnects
+    // and resumes from its last seq.
+    PolicyDisconnect Policy = "disconnect"
+)
+
+// ParsePolicy accepts the names above.
+func ParsePolicy(s string) (Policy, error) {
+    switch p := Policy(s); p {
+    case PolicyDropOldest, PolicyCoalesce, PolicyDisconnect:
+        return p, nil
+    }
+    return "", fmt.Errorf("hub: unknown backpressure policy %q", s)
+}
+
+// queue holds a client's outgoing frames. The hub pushes and the client's
+// writePump takes; ready is signalled whenever there is something new.
+type queue struct {
+    mu     sync.Mutex
+    frames []frame
+    max    int
+    ready  chan struct{}
+    closed bool
+    close  closeFrame
+}
+
+func newQueue(max int) *queue {
+    return &queue{max: max, ready: make(chan struct{}, 1)}
+}
+
+func (q *queue) signal() {
+    select {
+    case q.ready <- struct{}{}:
+    default:
+    }
+}
+
+// push queues f under policy p. It returns how many frames were dropped
+// to make room, and false if the client should be disconnected instead.
+func (q *queue) push(f frame, p Policy) (dropped int, ok bool) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if q.closed {
+        return 0, true
+    }
+    if p == PolicyCoalesce && f.coalesce != "" {
+        q.frames = slices.DeleteFunc(q.frames, func(g frame) bool { return g.coalesce == f.coalesce })
+    }
+    if len(q.frames) >= q.max {
+        switch p {
+        case PolicyDisconnect:
+            return 0, false
+        case PolicyCoalesce:
+            i := slices.IndexFunc(q.frames, func(g frame) bool { return g.coalesce == "" })
+            if i < 0 {
+                i = 0
+            }
+            q.frames = slices.Delete(q.frames, i, i+1)
+        default:
+            q.frames = slices.Delete(q.frames, 0, 1)
+        }
+        dropped = 1
+    }
+    q.frames = append(q.frames, f)
+    q.signal()
+    return dropped, true
+}
+
+// shut closes the queue; frames already queued are still delivered, then
+// the connection is closed with cf. Later calls are no-ops.
+func (q *queue) shut(cf closeFrame) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if q.closed {
+        return
+    }
+    q.closed = true
+    q.close = cf
+    q.signal()
+}
+
+// take returns everything queued, and whether the queue was shut and how.
+func (q *queue) take() ([]frame, bool, closeFrame) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    fs := q.frames
+    q.frames = nil
+    return fs, q.closed, q.close
+}
+
+// len returns the number of queued frames.
+func (q *queue) len() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return len(q.frames)
+}
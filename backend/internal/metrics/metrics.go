@@ -0,0 +1,291 @@
+// Package metrics keeps counters and histograms and writes them in the
+// Prometheus text exposition format, which is all /metrics needs and saves
+// pulling in the client library.
+package metrics
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+// DefaultBuckets are upper bounds in seconds suited to request latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry is a set of metric families, written in registration order.
+type Registry struct {
+    mu       sync.Mutex
+    families []family
+}
+
+type family interface {
+    write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry { return &Registry{} }
+
+func (r *Registry) add(f family) {
+    r.mu.Lock()
+    r.families = append(r.families, f)
+    r.mu.Unlock()
+}
+
+// WriteTo writes every family in the text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+    r.mu.Lock()
+    families := slices.Clone(r.families)
+    r.mu.Unlock()
+    cw := &countingWriter{w: w}
+    bw := bufio.NewWriter(cw)
+    for _, f := range families {
+        f.write(bw)
+    }
+    err := bw.Flush()
+    return cw.n, err
+}
+
+// Handler serves the registry for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+        r.WriteTo(w)
+    })
+}
+
+// desc is what every family has: a name, help text and label names.
+type desc struct {
+    name   string
+    help   string
+    kind   string
+    labels []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+}
+
+// sample writes one line; extra is a preformatted label pair such
+// as le="0.1", or empty.
+func (d *desc) sample(w *bufio.Writer, suffix string, values []string, extra string, v float64) {
+    w.WriteString(d.name)
+    w.WriteString(suffix)
+    if len(values) > 0 || extra != "" {
+        w.WriteByte('{')
+        for i, l := range d.labels {
+            if i > 0 {
+                w.WriteByte(',')
+            }
+            fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(values[i]))
+        }
+        if extra != "" {
+            if len(values) > 0 {
+                w.WriteByte(',')
+            }
+            w.WriteString(extra)
+        }
+        w.WriteByte('}')
+    }
+    w.WriteByte(' ')
+    w.WriteString(formatFloat(v))
+    w.WriteByte('\n')
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+    desc
+    mu     sync.Mutex
+    series map[string]*Counter
+}
+
+// Counter is one labelled series of a CounterVec.
+type Counter struct {
+    values []string
+    n      atomic.Uint64
+}
+
+// Counter registers a counter family. Its name should end in _total.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+    c := &CounterVec{desc: desc{name: name, help: help, kind: "counter", labels: labels}, series: make(map[string]*Counter)}
+    r.add(c)
+    return c
+}
+
+// With returns the series for the given label values, creating it at
+// zero. It panics if the number of values is wrong.
+func (c *CounterVec) With(values ...string) *Counter {
+    mustMatch(c.name, c.labels, values)
+    key := strings.Join(values, "\xff")
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    s, ok := c.series[key]
+    if !ok {
+        s = &Counter{values: slices.Clone(values)}
+        c.series[key] = s
+    }
+    return s
+}
+
+func (s *Counter) Inc()         { s.n.Add(1) }
+func (s *Counter) Add(n uint64) { s.n.Add(n) }
+
+func (c *CounterVec) write(w *bufio.Writer) {
+    c.header(w)
+    c.mu.Lock()
+    list := make([]*Counter, 0, len(c.series))
+    for _, s := range c.series {
+        list = append(list, s)
+    }
+    c.mu.Unlock()
+    slices.SortFunc(list, func(a, b *Counter) int { return slices.Compare(a.values, b.values) })
+    for _, s := range list {
+        c.sample(w, "", s.values, "", float64(s.n.Load()))
+    }
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct {
+    desc
+    buckets []float64
+    mu      sync.Mutex
+    series  map[string]*Histogram
+}
+
+// Histogram is one labelled series of a HistogramVec.
+type Histogram struct {
+    values  []string
+    buckets []float64
+    mu      sync.Mutex
+    counts  []uint64 // per bucket, not cumulative
+    count   uint64
+    sum     float64
+}
+
+// Histogram registers a histogram family with the given bucket upper
+// bounds, in increasing order; nil means DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+    if buckets == nil {
+        buckets = DefaultBuckets
+    }
+    h := &HistogramVec{desc: desc{name: name, help: help, kind: "histogram", labels: labels}, buckets: buckets, series: make(map[string]*Histogram)}
+    r.add(h)
+    return h
+}
+
+// With returns the series for the given label values, creating it empty.
+func (h *HistogramVec) With(values ...string) *Histogram {
+    mustMatch(h.name, h.labels, values)
+    key := strings.Join(values, "\xff")
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    s, ok := h.series[key]
+    if !ok {
+        s = &Histogram{values: slices.Clone(values), buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+        h.series[key] = s
+    }
+    return s
+}
+
+// Observe records v.
+func (s *Histogram) Observe(v float64) {
+    i, _ := slices.BinarySearch(s.buckets, v)
+    s.mu.Lock()
+    if i < len(s.counts) {
+        s.counts[i]++
+    }
+    s.count++
+    s.sum += v
+    s.mu.Unlock()
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+    h.header(w)
+    h.mu.Lock()
+    list := make([]*Histogram, 0, len(h.series))
+    for _, s := range h.series {
+        list = append(list, s)
+    }
+    h.mu.Unlock()
+    slices.SortFunc(list, func(a, b *Histogram) int { return slices.Compare(a.values, b.values) })
+    for _, s := range list {
+        s.mu.Lock()
+        counts, count, sum := slices.Clone(s.counts), s.count, s.sum
+        s.mu.Unlock()
+        var cum uint64
+        for i, le := range h.buckets {
+            cum += counts[i]
+            h.sample(w, "_bucket", s.values, `le="`+formatFloat(le)+`"`, float64(cum))
+        }
+        h.sample(w, "_bucket", s.values, `le="+Inf"`, float64(count))
+        h.sample(w, "_sum", s.values, "", sum)
+        h.sample(w, "_count", s.values, "", float64(count))
+    }
+}
+
+// Func is a family whose samples are read at scrape time, for values that
+// live elsewhere such as a hub's client count.
+type Func struct {
+    desc
+    collect func(emit func(v float64, values ...string))
+}
+
+// GaugeFunc registers a gauge family filled in by collect on each scrape.
+// collect calls emit once per series.
+func (r *Registry) GaugeFunc(name, help string, labels []string, collect func(emit func(v float64, values ...string))) {
+    r.add(&Func{desc: desc{name: name, help: help, kind: "gauge", labels: labels}, collect: collect})
+}
+
+// CounterFunc is GaugeFunc for values that only go up, such as counters
+// kept by a hub; a series may disappear when its owner goes away.
+func (r *Registry) CounterFunc(name, help string, labels []string, collect func(emit func(v float64, values ...string))) {
+    r.add(&Func{desc: desc{name: name, help: help, kind: "counter", labels: labels}, collect: collect})
+}
+
+func (f *Func) write(w *bufio.Writer) {
+    f.header(w)
+    f.collect(func(v float64, values ...string) {
+        mustMatch(f.name, f.labels, values)
+        f.sample(w, "", values, "", v)
+    })
+}
+
+func mustMatch(name string, labels, values []string) {
+    if len(labels) != len(values) {
+        panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", name, len(labels), len(values)))
+    }
+}
+
+func formatFloat(v float64) string {
+    switch {
+    case math.IsInf(v, 1):
+        return "+Inf"
+    case math.IsInf(v, -1):
+        return "-Inf"
+    }
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+    helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+    labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    n, err := c.w.Write(p)
+    c.n += int64(n)
+    return n, err
+}
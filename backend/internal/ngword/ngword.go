@@ -0,0 +1,338 @@
+// Package ngword matches comments against NG (forbidden) word rules after
+// normalising away the usual tricks: full-width letters, katakana/hiragana
+// swaps, inserted spaces or punctuation and zero-width characters.
+package ngword
+
+import (
+    "fmt"
+    "regexp"
+    "slices"
+    "unicode"
+    "unicode/utf8"
+)
+
+type Kind string
+
+const (
+    // KindSubstring matches anywhere, ignoring spaces and punctuation in
+    // between ("f u.c k" and "fu ck" match "fuck", as "死 ね" does "死ね").
+    // In scripts written with spaces between words, a match that reaches
+    // across a space must start at the beginning of a word, so "shit"
+    // doesn't match "this hit".
+    KindSubstring Kind = "substring"
+    // KindWord matches whole words only ("ass" doesn't match "class").
+    KindWord Kind = "word"
+    // KindRegex is an RE2 expression run against the normalised text
+    // (lowercase, hiragana, half-width ASCII).
+    KindRegex Kind = "regex"
+)
+
+// Action is what happens to a comment that matches a rule.
+type Action string
+
+const (
+    // ActionReject refuses the whole comment. It is the default.
+    ActionReject Action = "reject"
+    // ActionMask replaces the matched text with MaskText and lets the
+    // comment through.
+    ActionMask Action = "mask"
+    // ActionHold keeps the comment back for a moderator to review.
+    ActionHold Action = "hold"
+)
+
+// MaskText replaces masked spans.
+const MaskText = "***"
+
+// MaxPatternLen bounds the length of a single rule's pattern, in runes.
+const MaxPatternLen = 200
+
+// Rule is one NG entry as configured by a presenter or the server.
+type Rule struct {
+    ID      string `json:"id"`
+    Kind    Kind   `json:"kind"`
+    Pattern string `json:"pattern"`
+    // Action defaults to ActionReject when empty.
+    Action Action `json:"action,omitempty"`
+}
+
+// EffectiveAction returns the rule's action, applying the default.
+func (r Rule) EffectiveAction() Action {
+    if r.Action == "" {
+        return ActionReject
+    }
+    return r.Action
+}
+
+// Validate reports whether the rule can be compiled.
+func (r Rule) Validate() error {
+    _, err := compile(r)
+    return err
+}
+
+// Match is a rule hit, as a rune range [Start, End) of the original text.
+type Match struct {
+    Rule  Rule
+    Start int
+    End   int
+}
+
+// List is a compiled, read-only set of rules, safe for concurrent use.
+type List struct {
+    rules []compiled
+}
+
+type compiled struct {
+    Rule
+    words [][]rune // pattern split into words, for word rules
+    norm  []rune   // pattern without separators, for substring rules
+    re    *regexp.Regexp
+}
+
+// Compile prepares rules for matching. It fails on the first invalid rule.
+func Compile(rules []Rule) (*List, error) {
+    l := &List{}
+    for _, r := range rules {
+        c, err := compile(r)
+        if err != nil {
+            return nil, err
+        }
+        l.rules = append(l.rules, c)
+    }
+    return l, nil
+}
+
+func compile(r Rule) (compiled, error) {
+    c := compiled{Rule: r}
+    if r.Pattern == "" {
+        return c, fmt.Errorf("ngword: empty pattern")
+    }
+    if utf8.RuneCountInString(r.Pattern) > MaxPatternLen {
+        return c, fmt.Errorf("ngword: pattern longer than %d", MaxPatternLen)
+    }
+    switch r.Action {
+    case "", ActionReject, ActionMask, ActionHold:
+    default:
+        return c, fmt.Errorf("ngword: unknown action %q", r.Action)
+    }
+    switch r.Kind {
+    case KindSubstring:
+        for _, r := range Normalize(r.Pattern).Norm {
+            if isWordRune(r) {
+                c.norm = append(c.norm, r)
+            }
+        }
+        if len(c.norm) == 0 {
+            return c, fmt.Errorf("ngword: pattern %q has no letters", r.Pattern)
+        }
+    case KindWord:
+        c.words = words(Normalize(r.Pattern).Norm)
+        if len(c.words) == 0 {
+            return c, fmt.Errorf("ngword: pattern %q has no letters", r.Pattern)
+        }
+    case KindRegex:
+        re, err := regexp.Compile(r.Pattern)
+        if err != nil {
+            return c, fmt.Errorf("ngword: %w", err)
+        }
+        c.re = re
+    default:
+        return c, fmt.Errorf("ngword: unknown kind %q", r.Kind)
+    }
+    return c, nil
+}
+
+// Len returns the number of rules in the list.
+func (l *List) Len() int {
+    if l == nil {
+        return 0
+    }
+    return len(l.rules)
+}
+
+// Match returns every hit of every rule in text, in rule order. A nil List
+// matches nothing.
+func (l *List) Match(text string) []Match {
+    if l.Len() == 0 {
+        return nil
+    }
+    t := Normalize(text)
+    var out []Match
+    for _, c := range l.rules {
+        switch c.Kind {
+        case KindSubstring:
+            out = append(out, matchSubstring(t, c)...)
+        case KindWord:
+            out = append(out, matchWords(t, c)...)
+        case KindRegex:
+            out = append(out, matchRegex(t, c)...)
+        }
+    }
+    return out
+}
+
+// Decide returns the strictest action among matches: reject beats hold,
+// hold beats mask. It returns "" when there are no matches.
+func Decide(matches []Match) Action {
+    var out Action
+    for _, m := range matches {
+        switch a := m.Rule.EffectiveAction(); {
+        case a == ActionReject:
+            return ActionReject
+        case a == ActionHold:
+            out = ActionHold
+        case a == ActionMask && out == "":
+            out = ActionMask
+        }
+    }
+    return out
+}
+
+// Mask replaces every matched span of text with MaskText. Overlapping or
+// adjacent spans are masked once.
+func Mask(text string, matches []Match) string {
+    if len(matches) == 0 {
+        return text
+    }
+    src := []rune(text)
+    masked := make([]bool, len(src))
+    for _, m := range matches {
+        for i := m.Start; i < m.End && i < len(src); i++ {
+            masked[i] = true
+        }
+    }
+    out := make([]rune, 0, len(src))
+    for i := 0; i < len(src); i++ {
+        if !masked[i] {
+            out = append(out, src[i])
+            continue
+        }
+        out = append(out, []rune(MaskText)...)
+        for i+1 < len(src) && masked[i+1] {
+            i++
+        }
+    }
+    return string(out)
+}
+
+// words splits rs into runs of word runes.
+func words(rs []rune) [][]rune {
+    var out [][]rune
+    start := -1
+    for i, r := range rs {
+        if isWordRune(r) {
+            if start < 0 {
+                start = i
+            }
+        } else if start >= 0 {
+            out = append(out, rs[start:i])
+            start = -1
+        }
+    }
+    if start >= 0 {
+        out = append(out, rs[start:])
+    }
+    return out
+}
+
+// matchSubstring finds the rule's pattern in t with separators removed.
+// idx maps each remaining rune back to t.Norm for masking.
+func matchSubstring(t Text, c compiled) []Match {
+    var text []rune
+    var idx []int
+    for i, r := range t.Norm {
+        if isWordRune(r) {
+            text = append(text, r)
+            idx = append(idx, i)
+        }
+    }
+    n := len(c.norm)
+    var out []Match
+    for i := 0; i+n <= len(text); {
+        if slices.Equal(text[i:i+n], c.norm) && joined(text, idx, i, i+n) {
+            start, end := t.span(idx[i], idx[i+n-1]+1)
+            out = append(out, Match{Rule: c.Rule, Start: start, End: end})
+            i += n
+            continue
+        }
+        i++
+    }
+    return out
+}
+
+// joined reports whether text[i:j] can be read as one word. It can if no
+// separator was removed from it, if it is written in a script without
+// spaces between words, or if it starts a word: "fu ck" is one word
+// pulled apart, "this hit" is two.
+func joined(text []rune, idx []int, i, j int) bool {
+    if idx[j-1]-idx[i] == j-1-i || unspaced(text[i]) {
+        return true
+    }
+    return i == 0 || idx[i-1] != idx[i]-1
+}
+
+// unspaced reports whether r belongs to a script written without spaces
+// between words.
+func unspaced(r rune) bool {
+    return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+func matchWords(t Text, c compiled) []Match {
+    // word boundaries in t.Norm
+    type word struct{ start, end int }
+    var ws []word
+    start := -1
+    for i, r := range t.Norm {
+        if isWordRune(r) {
+            if start < 0 {
+                start = i
+            }
+        } else if start >= 0 {
+            ws = append(ws, word{start, i})
+            start = -1
+        }
+    }
+    if start >= 0 {
+        ws = append(ws, word{start, len(t.Norm)})
+    }
+    var out []Match
+    n := len(c.words)
+    for i := 0; i+n <= len(ws); i++ {
+        ok := true
+        for k := 0; k < n; k++ {
+            w := ws[i+k]
+            if !slices.Equal(t.Norm[w.start:w.end], c.words[k]) {
+                ok = false
+                break
+            }
+        }
+        if ok {
+            s, e := t.span(ws[i].start, ws[i+n-1].end)
+            out = append(out, Match{Rule: c.Rule, Start: s, End: e})
+            i += n - 1
+        }
+    }
+    return out
+}
+
+func matchRegex(t Text, c compiled) []Match {
+    norm := string(t.Norm)
+    // runeAt[b] is the rune index of the rune starting at byte offset b;
+    // match boundaries always fall on rune starts
+    runeAt := make([]int, len(norm)+1)
+    ri := 0
+    for b := range norm {
+        runeAt[b] = ri
+        ri++
+    }
+    runeAt[len(norm)] = ri
+    var out []Match
+    for _, loc := range c.re.FindAllStringIndex(norm, -1) {
+        s, e := runeAt[loc[0]], runeAt[loc[1]]
+        if s == e {
+            continue // empty match, nothing to report
+        }
+        start, end := t.span(s, e)
+        out = append(out, Match{Rule: c.Rule, Start: start, End: end})
+    }
+    return out
+}
@@ -0,0 +1,125 @@
+package ngword
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+    tests := []struct {
+        in, want string
+    }{
+        {"FUCK", "fuck"},
+        {"ｆｕｃｋ", "fuck"},
+        {"𝐟𝐮𝐜𝐤", "fuck"},
+        {"ⓕⓤⓒⓚ", "fuck"},
+        {"f​u‍ck", "fuck"},
+        {"シネ", "しね"},
+        {"ｼﾈ", "しね"},
+        {"ﾀﾞﾒ", "だめ"},
+        {"が", "が"},
+        {"は゜", "ぱ"},
+        {"ﬁne", "fine"},
+        {"a　b", "a b"},
+    }
+    for _, tt := range tests {
+        if got := string(Normalize(tt.in).Norm); got != tt.want {
+            t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestMatch(t *testing.T) {
+    sub := func(p string) Rule { return Rule{Kind: KindSubstring, Pattern: p} }
+    word := func(p string) Rule { return Rule{Kind: KindWord, Pattern: p} }
+    tests := []struct {
+        rule Rule
+        text string
+        want bool
+    }{
+        // substring rules match inside words...
+        {sub("shit"), "shit", true},
+        {sub("shit"), "bullshit!", true},
+        {sub("fuck"), "fucking", true},
+        // ...and across inserted spaces and punctuation
+        {sub("fuck"), "f u c k", true},
+        {sub("fuck"), "I f u c k", true},
+        {sub("fuck"), "f.u.c.k", true},
+        {sub("fuck"), "ｆ　ｕ　ｃ　ｋ", true},
+        {sub("fuck"), "fu ck you", true},
+        {sub("fuck"), "ｆｕ ｃｋ", true},
+        {sub("fuck"), "fu-cking", true},
+        // but not from the middle of one word into the next
+        {sub("shit"), "this hit me", false},
+        {sub("shit"), "his hit", false},
+        {sub("shit"), "this-hit", false},
+        // compatibility forms and invisible characters
+        {sub("fuck"), "𝐟𝐮𝐜𝐤", true},
+        {sub("fuck"), "ⓕⓤⓒⓚ", true},
+        {sub("fuck"), "FUCK", true},
+        {sub("fuck"), "fu​ck", true},
+        // Japanese has no spaces between words, so any separator is ignored
+        {sub("死ね"), "お前死ね", true},
+        {sub("死ね"), "死、ね", true},
+        {sub("死ね"), "お前は死 ねよ", true},
+        {sub("死ね"), "死 ねよ", true},
+        {sub("しね"), "シネ", true},
+        {sub("しね"), "し　ね", true},
+        {sub("だめ"), "ﾀﾞﾒ", true},
+        // spaces in the pattern are ignored too
+        {sub("ass hole"), "big asshole", true},
+        {sub("ass hole"), "big ass holes", true},
+        {sub("ass hole"), "a s s hole", true},
+        {sub("ass hole"), "glass hole", false},
+        {sub("ass hole"), "ass, the hole", false},
+        // word rules
+        {word("ass"), "class", false},
+        {word("ass"), "kick ass", true},
+        {word("kick ass"), "kick, ass!", true},
+        {Rule{Kind: KindRegex, Pattern: `^\d{3}$`}, "１２３", true},
+    }
+    for _, tt := range tests {
+        l, err := Compile([]Rule{tt.rule})
+        if err != nil {
+            t.Fatalf("Compile(%+v): %v", tt.rule, err)
+        }
+        if got := len(l.Match(tt.text)) > 0; got != tt.want {
+            t.Errorf("%s %q on %q: matched %v, want %v", tt.rule.Kind, tt.rule.Pattern, tt.text, got, tt.want)
+        }
+    }
+}
+
+func TestMask(t *testing.T) {
+    tests := []struct {
+        pattern, text, want string
+    }{
+        {"shit", "oh shit!", "oh ***!"},
+        {"shit", "this hit", "this hit"},
+        {"fuck", "f u c k off", "*** off"},
+        {"fuck", "fu ck off", "*** off"},
+        {"死ね", "お前は死 ねよ", "お前は***よ"},
+        {"fuck", "𝐟𝐮𝐜𝐤 off", "*** off"},
+        {"だめ", "それはﾀﾞﾒ", "それは***"},
+    }
+    for _, tt := range tests {
+        l, err := Compile([]Rule{{Kind: KindSubstring, Pattern: tt.pattern, Action: ActionMask}})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := Mask(tt.text, l.Match(tt.text)); got != tt.want {
+            t.Errorf("Mask(%q) with %q = %q, want %q", tt.text, tt.pattern, got, tt.want)
+        }
+    }
+}
+
+func TestCompileRejects(t *testing.T) {
+    for _, r := range []Rule{
+        {Kind: KindSubstring, Pattern: ""},
+        {Kind: KindSubstring, Pattern: "!!!"},
+        {Kind: KindWord, Pattern: "   "},
+        {Kind: KindRegex, Pattern: "("},
+        {Kind: "fuzzy", Pattern: "x"},
+        {Kind: KindSubstring, Pattern: "x", Action: "delete"},
+    } {
+        if _, err := Compile([]Rule{r}); err == nil {
+            t.Errorf("Compile(%+v) succeeded", r)
+        }
+    }
+}
@@ -0,0 +1,127 @@
+package ngword
+
+import (
+    "unicode"
+    "unicode/utf8"
+
+    "golang.org/x/text/unicode/norm"
+)
+
+// Text is a normalised form of a comment that remembers where each rune came
+// from, so matches can be reported (and masked) in the original text.
+type Text struct {
+    Src  []rune
+    Norm []rune
+    // Norm[i] was produced from Src[from[i]:to[i]]
+    from []int
+    to   []int
+}
+
+// Normalize folds s so that visually or phonetically equivalent spellings
+// compare equal:
+//
+//   - zero-width and other invisible format characters are dropped
+//   - each character is NFKC-normalised, so full-width, mathematical,
+//     circled and other compatibility forms become the plain letter
+//     ("ｆ", "𝐟" and "ⓕ" all become "f") and half-width katakana become
+//     full-width
+//   - a (semi-)voiced sound mark, spacing, combining or half-width, joins
+//     the kana before it
+//   - katakana fold to hiragana
+//   - letters are lowercased
+//
+// NFKC is applied a character at a time so every normalised rune can be
+// traced back to the one it came from.
+func Normalize(s string) Text {
+    src := []rune(s)
+    t := Text{Src: src, Norm: make([]rune, 0, len(src))}
+    var buf [utf8.UTFMax * 4]byte
+    for i, r := range src {
+        if isInvisible(r) {
+            continue
+        }
+        // A voiced sound mark combines with the kana before it. NFKC
+        // would turn the spacing forms into a space and a combining mark.
+        if mark := soundMark(r); mark != 0 && len(t.Norm) > 0 {
+            if v, ok := voiced(t.Norm[len(t.Norm)-1], mark); ok {
+                t.Norm[len(t.Norm)-1] = v
+                t.to[len(t.to)-1] = i + 1
+                continue
+            }
+        }
+        folded := []rune{r}
+        if r >= utf8.RuneSelf {
+            folded = []rune(string(norm.NFKC.Append(buf[:0], []byte(string(r))...)))
+        }
+        for _, f := range folded {
+            if isInvisible(f) {
+                continue
+            }
+            t.Norm = append(t.Norm, unicode.ToLower(foldKana(f)))
+            t.from = append(t.from, i)
+            t.to = append(t.to, i+1)
+        }
+    }
+    return t
+}
+
+// span maps the normalised range [start, end) back to the original runes.
+func (t Text) span(start, end int) (int, int) {
+    return t.from[start], t.to[end-1]
+}
+
+func isInvisible(r rune) bool {
+    switch {
+    case r == 0x00AD, r == 0x180E, r == 0xFEFF:
+        return true
+    case r >= 0x200B && r <= 0x200F: // ZWSP, ZWNJ, ZWJ, LRM, RLM
+        return true
+    case r >= 0x202A && r <= 0x202E: // bidi embedding/override
+        return true
+    case r >= 0x2060 && r <= 0x2064: // word joiner, invisible operators
+        return true
+    case r >= 0xFE00 && r <= 0xFE0F: // variation selectors
+        return true
+    }
+    return false
+}
+
+// soundMark returns 1 for a voiced mark (dakuten) and 2 for a semi-voiced
+// mark (handakuten), in any of their spacing, combining or half-width forms.
+func soundMark(r rune) int {
+    switch r {
+    case 0xFF9E, 0x3099, 0x309B:
+        return 1
+    case 0xFF9F, 0x309A, 0x309C:
+        return 2
+    }
+    return 0
+}
+
+// voiced returns the kana r with the given sound mark applied.
+func voiced(r rune, mark int) (rune, bool) {
+    h := foldKana(r)
+    switch {
+    case mark == 1 && h == 'う':
+        return 'ゔ', true
+    case mark == 1 && ((h >= 'か' && h <= 'ぢ' && (h-'か')%2 == 0) || (h >= 'つ' && h <= 'と' && (h-'つ')%2 == 0)):
+        return h + 1, true
+    case h >= 'は' && h <= 'ほ' && (h-'は')%3 == 0:
+        return h + rune(mark), true
+    }
+    return 0, false
+}
+
+// foldKana maps katakana to the corresponding hiragana.
+func foldKana(r rune) rune {
+    if r >= 0x30A1 && r <= 0x30F6 {
+        return r - 0x60
+    }
+    return r
+}
+
+// isWordRune reports whether r is part of a word; everything else (spaces,
+// punctuation, symbols) separates words and is ignored by substring rules.
+func isWordRune(r rune) bool {
+    return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
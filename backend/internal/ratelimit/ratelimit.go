@@ -0,0 +1,175 @@
+// Package ratelimit implements keyed token buckets. One Limiter holds the
+// buckets for every key, each checked against the Limit passed with it, so
+// callers can combine per-user, per-room and global limits in one decision.
+package ratelimit
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Limit allows Rate events per second on average, with bursts of up to
+// Burst. The zero Limit allows everything.
+type Limit struct {
+    Rate  float64
+    Burst int
+}
+
+// Every returns a Limit of one event per interval with the given burst.
+// A zero interval means no limit.
+func Every(interval time.Duration, burst int) Limit {
+    if interval <= 0 {
+        return Limit{}
+    }
+    return Limit{Rate: float64(time.Second) / float64(interval), Burst: burst}
+}
+
+// Unlimited reports whether l lets everything through.
+func (l Limit) Unlimited() bool { return l.Rate <= 0 || l.Burst <= 0 }
+
+func (l Limit) String() string {
+    if l.Unlimited() {
+        return "off"
+    }
+    return strconv.FormatFloat(l.Rate, 'f', -1, 64) + "/s:" + strconv.Itoa(l.Burst)
+}
+
+// ParseLimit reads a limit written as "<n>/<unit>[:<burst>]", where unit is
+// s, m or h, e.g. "20/s:40" or "30/m". Without a burst it is the larger of
+// one and one second's worth; a given burst must be at least one, as none
+// would refuse everything. "off" or "0" disables the limit.
+func ParseLimit(s string) (Limit, error) {
+    s = strings.TrimSpace(s)
+    if s == "off" || s == "0" {
+        return Limit{}, nil
+    }
+    rate, burst, hasBurst := strings.Cut(s, ":")
+    n, unit, ok := strings.Cut(rate, "/")
+    if !ok {
+        return Limit{}, fmt.Errorf("ratelimit: %q: want <n>/<unit>[:<burst>]", s)
+    }
+    count, err := strconv.ParseFloat(n, 64)
+    if err != nil || count < 0 {
+        return Limit{}, fmt.Errorf("ratelimit: %q: bad count", s)
+    }
+    var per time.Duration
+    switch unit {
+    case "s":
+        per = time.Second
+    case "m":
+        per = time.Minute
+    case "h":
+        per = time.Hour
+    default:
+        return Limit{}, fmt.Errorf("ratelimit: %q: unit must be s, m or h", s)
+    }
+    l := Limit{Rate: count * float64(time.Second) / float64(per)}
+    if hasBurst {
+        b, err := strconv.Atoi(burst)
+        if err != nil || b < 1 {
+            return Limit{}, fmt.Errorf("ratelimit: %q: burst must be a positive integer", s)
+        }
+        l.Burst = b
+    } else {
+        l.Burst = max(1, int(math.Ceil(l.Rate)))
+    }
+    return l, nil
+}
+
+// minRetry is the shortest wait Allow reports. A bucket a hair short of a
+// token still refuses, and a wait that rounds to zero would tell clients
+// (who see it in milliseconds) to retry at once.
+const minRetry = time.Millisecond
+
+// Check is one bucket a request must draw a token from.
+type Check struct {
+    Key   string
+    Limit Limit
+}
+
+type bucket struct {
+    tokens float64
+    last   time.Time
+    limit  Limit
+}
+
+// refill returns the bucket's tokens at now under limit l.
+func (b *bucket) refill(l Limit, now time.Time) float64 {
+    t := b.tokens + now.Sub(b.last).Seconds()*l.Rate
+    return math.Min(t, float64(l.Burst))
+}
+
+// Limiter is a set of token buckets keyed by string. It is safe for
+// concurrent use.
+type Limiter struct {
+    mu      sync.Mutex
+    buckets map[string]*bucket
+}
+
+func New() *Limiter {
+    return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Allow takes one token from every checked bucket, or from none of them if
+// any is empty. When it refuses, retryAfter is how long until all of them
+// would have a token again.
+func (l *Limiter) Allow(now time.Time, checks ...Check) (ok bool, retryAfter time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    denied := false
+    for _, c := range checks {
+        if c.Limit.Unlimited() {
+            continue
+        }
+        b := l.buckets[c.Key]
+        if b == nil {
+            continue // a new bucket starts full
+        }
+        if t := b.refill(c.Limit, now); t < 1 {
+            wait := time.Duration(math.Ceil((1 - t) / c.Limit.Rate * float64(time.Second)))
+            retryAfter = max(retryAfter, wait, minRetry)
+            denied = true
+        }
+    }
+    if denied {
+        return false, retryAfter
+    }
+    for _, c := range checks {
+        if c.Limit.Unlimited() {
+            continue
+        }
+        b := l.buckets[c.Key]
+        if b == nil {
+            b = &bucket{tokens: float64(c.Limit.Burst), last: now}
+            l.buckets[c.Key] = b
+        }
+        b.tokens = b.refill(c.Limit, now) - 1
+        b.last = now
+        b.limit = c.Limit
+    }
+    return true, 0
+}
+
+// Evict drops buckets that have refilled completely by now; they would
+// behave exactly like new ones. It returns how many are left.
+func (l *Limiter) Evict(now time.Time) int {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    for k, b := range l.buckets {
+        if b.refill(b.limit, now) >= float64(b.limit.Burst) {
+            delete(l.buckets, k)
+        }
+    }
+    return len(l.buckets)
+}
+
+// Len returns the number of live buckets.
+func (l *Limiter) Len() int {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return len(l.buckets)
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseLimit(t *testing.T) {
+    tests := []struct {
+        in   string
+        want Limit
+    }{
+        {"20/s:40", Limit{Rate: 20, Burst: 40}},
+        {"30/m", Limit{Rate: 0.5, Burst: 1}},
+        {"3600/h:5", Limit{Rate: 1, Burst: 5}},
+        {"2.5/s", Limit{Rate: 2.5, Burst: 3}},
+        {" 1/s:1 ", Limit{Rate: 1, Burst: 1}},
+        {"off", Limit{}},
+        {"0", Limit{}},
+    }
+    for _, tt := range tests {
+        got, err := ParseLimit(tt.in)
+        if err != nil {
+            t.Errorf("ParseLimit(%q): %v", tt.in, err)
+        } else if got != tt.want {
+            t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.in, got, tt.want)
+        }
+    }
+    for _, in := range []string{"", "20", "20/d", "x/s", "-1/s", "20/s:", "20/s:0", "20/s:-1", "20/s:1.5"} {
+        if l, err := ParseLimit(in); err == nil {
+            t.Errorf("ParseLimit(%q) = %+v, want an error", in, l)
+        }
+    }
+}
+
+func TestBurstAndRefill(t *testing.T) {
+    l := New()
+    now := time.Unix(1000, 0)
+    c := Check{Key: "k", Limit: Limit{Rate: 2, Burst: 3}}
+    for i := 0; i < 3; i++ {
+        if ok, _ := l.Allow(now, c); !ok {
+            t.Fatalf("post %d of the burst refused", i+1)
+        }
+    }
+    ok, wait := l.Allow(now, c)
+    if ok || wait != 500*time.Millisecond {
+        t.Fatalf("after the burst: ok %v, wait %v; want refused for 500ms", ok, wait)
+    }
+    if ok, _ := l.Allow(now.Add(499*time.Millisecond), c); ok {
+        t.Fatal("allowed before a token refilled")
+    }
+    if ok, _ := l.Allow(now.Add(500*time.Millisecond), c); !ok {
+        t.Fatal("refused after a token refilled")
+    }
+    // a long pause refills up to the burst, not beyond
+    later := now.Add(time.Hour)
+    for i := 0; i < 3; i++ {
+        if ok, _ := l.Allow(later, c); !ok {
+            t.Fatalf("post %d after refilling refused", i+1)
+        }
+    }
+    if ok, _ := l.Allow(later, c); ok {
+        t.Fatal("bucket refilled past its burst")
+    }
+}
+
+func TestAlmostAToken(t *testing.T) {
+    l := New()
+    now := time.Unix(1000, 0)
+    c := Check{Key: "k", Limit: Limit{Rate: 1e12, Burst: 1}}
+    l.Allow(now, c)
+    // a fraction of a nanosecond's refill short of a token
+    l.buckets["k"].tokens = 1 - 1e-6
+    ok, wait := l.Allow(now, c)
+    if ok {
+        t.Fatal("allowed with less than a token")
+    }
+    if wait != minRetry {
+        t.Errorf("wait %v, want %v", wait, minRetry)
+    }
+}
+
+func TestMultipleChecks(t *testing.T) {
+    l := New()
+    now := time.Unix(1000, 0)
+    user := func(id string) Check { return Check{Key: "user|" + id, Limit: Limit{Rate: 1, Burst: 1}} }
+    room := Check{Key: "room", Limit: Limit{Rate: 1, Burst: 2}}
+
+    if ok, _ := l.Allow(now, user("a"), room); !ok {
+        t.Fatal("a's first post refused")
+    }
+    // a's own bucket is empty; the room's token must not be spent
+    if ok, wait := l.Allow(now, user("a"), room); ok || wait != time.Second {
+        t.Fatalf("a's second post: ok %v, wait %v", ok, wait)
+    }
+    if ok, _ := l.Allow(now, user("b"), room); !ok {
+        t.Fatal("b's post refused though the room had a token left")
+    }
+    // now the room is empty too; the wait is the longest of the buckets
+    slow := Check{Key: "user|c", Limit: Limit{Rate: 0.25, Burst: 1}}
+    if ok, _ := l.Allow(now, slow); !ok {
+        t.Fatal("c's first post refused")
+    }
+    if ok, wait := l.Allow(now, slow, room); ok || wait != 4*time.Second {
+        t.Fatalf("c's post: ok %v, wait %v; want refused for 4s", ok, wait)
+    }
+    if ok, wait := l.Allow(now, user("d"), room); ok || wait != time.Second {
+        t.Fatalf("d's post: ok %v, wait %v; want refused for 1s", ok, wait)
+    }
+    // unlimited checks never refuse and keep no bucket
+    if ok, _ := l.Allow(now, Check{Key: "off", Limit: Limit{}}); !ok {
+        t.Fatal("unlimited check refused")
+    }
+    if _, found := l.buckets["off"]; found {
+        t.Error("unlimited check kept a bucket")
+    }
+}
+
+func TestEvict(t *testing.T) {
+    l := New()
+    now := time.Unix(1000, 0)
+    l.Allow(now, Check{Key: "slow", Limit: Limit{Rate: 0.1, Burst: 1}})
+    l.Allow(now, Check{Key: "fast", Limit: Limit{Rate: 10, Burst: 1}})
+    if n := l.Evict(now.Add(time.Second)); n != 1 {
+        t.Fatalf("Evict left %d buckets, want 1", n)
+    }
+    if _, found := l.buckets["slow"]; !found {
+        t.Error("evicted a bucket still refilling")
+    }
+}
@@ -0,0 +1,162 @@
+package resp
+
+import (
+    "log/slog"
+    "sync"
+    "time"
+)
+
+// PubSub is a subscriber connection. Messages are handed to a single
+// callback on the connection's read goroutine, so it should not block.
+// After a dropped connection it redials and resubscribes on its own;
+// messages published in between are lost.
+type PubSub struct {
+    opts      Options
+    onMessage func(channel string, payload []byte)
+
+    mu       sync.Mutex
+    cn       *conn
+    channels map[string]bool
+    closed   bool
+    done     chan struct{}
+}
+
+// NewPubSub starts a subscriber connection with no subscriptions.
+func NewPubSub(o Options, onMessage func(channel string, payload []byte)) *PubSub {
+    ps := &PubSub{opts: o, onMessage: onMessage, channels: map[string]bool{}, done: make(chan struct{})}
+    go ps.run()
+    return ps
+}
+
+// Subscribe adds channels. It takes effect on the current connection if
+// there is one, and on every reconnect.
+func (ps *PubSub) Subscribe(channels ...string) error {
+    return ps.change("SUBSCRIBE", true, channels)
+}
+
+// Unsubscribe removes channels.
+func (ps *PubSub) Unsubscribe(channels ...string) error {
+    return ps.change("UNSUBSCRIBE", false, channels)
+}
+
+func (ps *PubSub) change(cmd string, on bool, channels []string) error {
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    for _, ch := range channels {
+        if on {
+            ps.channels[ch] = true
+        } else {
+            delete(ps.channels, ch)
+        }
+    }
+    if ps.cn == nil || len(channels) == 0 {
+        return nil
+    }
+    // The reply arrives on the read loop, which ignores it.
+    ps.cn.c.SetWriteDeadline(time.Now().Add(dialTimeout))
+    defer ps.cn.c.SetWriteDeadline(time.Time{})
+    return ps.cn.write(append([]string{cmd}, channels...)...)
+}
+
+// Connected reports whether the subscriber connection is up.
+func (ps *PubSub) Connected() bool {
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    return ps.cn != nil
+}
+
+// Close ends the subscriber connection.
+func (ps *PubSub) Close() error {
+    ps.mu.Lock()
+    if ps.closed {
+        ps.mu.Unlock()
+        return nil
+    }
+    ps.closed = true
+    cn := ps.cn
+    ps.mu.Unlock()
+    close(ps.done)
+    if cn != nil {
+        return cn.c.Close()
+    }
+    return nil
+}
+
+func (ps *PubSub) run() {
+    backoff := 100 * time.Millisecond
+    for {
+        cn, err := ps.connect()
+        if err == nil {
+            backoff = 100 * time.Millisecond
+            err = ps.readLoop(cn)
+        }
+        select {
+        case <-ps.done:
+            return
+        default:
+        }
+        slog.Warn("pubsub connection lost, reconnecting", "addr", ps.opts.Addr, "err", err, "backoff", backoff.String())
+        select {
+        case <-time.After(backoff):
+        case <-ps.done:
+            return
+        }
+        backoff = min(backoff*2, 5*time.Second)
+    }
+}
+
+// connect dials and subscribes to every channel wanted so far.
+func (ps *PubSub) connect() (*conn, error) {
+    cn, err := dial(ps.opts)
+    if err != nil {
+        return nil, err
+    }
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    if ps.closed {
+        cn.c.Close()
+        return nil, errClosed
+    }
+    if len(ps.channels) > 0 {
+        args := []string{"SUBSCRIBE"}
+        for ch := range ps.channels {
+            args = append(args, ch)
+        }
+        if err := cn.write(args...); err != nil {
+            cn.c.Close()
+            return nil, err
+        }
+    }
+    ps.cn = cn
+    return cn, nil
+}
+
+func (ps *PubSub) readLoop(cn *conn) error {
+    defer func() {
+        ps.mu.Lock()
+        if ps.cn == cn {
+            ps.cn = nil
+        }
+        ps.mu.Unlock()
+        cn.c.Close()
+    }()
+    for {
+        v, err := cn.read()
+        if err != nil {
+            return err
+        }
+        msg, ok := v.([]any)
+        if !ok || len(msg) != 3 {
+            continue
+        }
+        kind, _ := msg[0].([]byte)
+        if string(kind) != "message" {
+            continue // subscribe/unsubscribe confirmations
+        }
+        ch, _ := msg[1].([]byte)
+        payload, _ := msg[2].([]byte)
+        ps.onMessage(string(ch), payload)
+    }
+}
+
+var errClosed = Error("pubsub closed")
@@ -0,0 +1,281 @@
+// Package resp is a small client for the Redis protocol (RESP2), covering
+// what the room store and broker need: plain commands and pub/sub. Anything
+// that speaks the protocol (Redis, Valkey, KeyDB, a test stand-in) works.
+package resp
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Error is an error reply from the server.
+type Error string
+
+func (e Error) Error() string { return "resp: " + string(e) }
+
+// ErrNil is returned for a nil reply, e.g. GET of a missing key.
+var ErrNil = errors.New("resp: nil")
+
+// Options are the connection settings parsed from a redis:// URL.
+type Options struct {
+    Addr     string
+    Username string
+    Password string
+    DB       int
+}
+
+// ParseURL reads redis://[user:password@]host[:port][/db].
+func ParseURL(s string) (Options, error) {
+    u, err := url.Parse(s)
+    if err != nil {
+        return Options{}, err
+    }
+    if u.Scheme != "redis" {
+        return Options{}, fmt.Errorf("resp: unsupported scheme %q", u.Scheme)
+    }
+    o := Options{Addr: u.Host}
+    if u.Port() == "" {
+        o.Addr = net.JoinHostPort(u.Hostname(), "6379")
+    }
+    if u.User != nil {
+        o.Username = u.User.Username()
+        o.Password, _ = u.User.Password()
+    }
+    if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+        if o.DB, err = strconv.Atoi(db); err != nil {
+            return Options{}, fmt.Errorf("resp: bad db %q", db)
+        }
+    }
+    return o, nil
+}
+
+// dialTimeout bounds connecting and each command round trip.
+const dialTimeout = 5 * time.Second
+
+// conn is one connection with its buffered reader.
+type conn struct {
+    c net.Conn
+    r *bufio.Reader
+}
+
+func dial(o Options) (*conn, error) {
+    c, err := net.DialTimeout("tcp", o.Addr, dialTimeout)
+    if err != nil {
+        return nil, err
+    }
+    cn := &conn{c: c, r: bufio.NewReader(c)}
+    if o.Password != "" {
+        args := []string{"AUTH", o.Password}
+        if o.Username != "" {
+            args = []string{"AUTH", o.Username, o.Password}
+        }
+        if _, err := cn.do(args...); err != nil {
+            c.Close()
+            return nil, err
+        }
+    }
+    if o.DB != 0 {
+        if _, err := cn.do("SELECT", strconv.Itoa(o.DB)); err != nil {
+            c.Close()
+            return nil, err
+        }
+    }
+    return cn, nil
+}
+
+func (cn *conn) write(args ...string) error {
+    var b strings.Builder
+    fmt.Fprintf(&b, "*%d\r\n", len(args))
+    for _, a := range args {
+        fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+    }
+    _, err := io.WriteString(cn.c, b.String())
+    return err
+}
+
+func (cn *conn) do(args ...string) (any, error) {
+    cn.c.SetDeadline(time.Now().Add(dialTimeout))
+    defer cn.c.SetDeadline(time.Time{})
+    if err := cn.write(args...); err != nil {
+        return nil, err
+    }
+    return cn.read()
+}
+
+// read parses one reply: string, int64, []byte, []any, nil or Error.
+func (cn *conn) read() (any, error) {
+    line, err := cn.r.ReadString('\n')
+    if err != nil {
+        return nil, err
+    }
+    line = strings.TrimSuffix(line, "\r\n")
+    if line == "" {
+        return nil, errors.New("resp: empty reply")
+    }
+    switch line[0] {
+    case '+':
+        return line[1:], nil
+    case '-':
+        return Error(line[1:]), nil
+    case ':':
+        return strconv.ParseInt(line[1:], 10, 64)
+    case '$':
+        n, err := strconv.Atoi(line[1:])
+        if err != nil {
+            return nil, err
+        }
+        if n < 0 {
+            return nil, nil
+        }
+        b := make([]byte, n+2)
+        if _, err := io.ReadFull(cn.r, b); err != nil {
+            return nil, err
+        }
+        return b[:n], nil
+    case '*':
+        n, err := strconv.Atoi(line[1:])
+        if err != nil {
+            return nil, err
+        }
+        if n < 0 {
+            return nil, nil
+        }
+        out := make([]any, n)
+        for i := range out {
+            if out[i], err = cn.read(); err != nil {
+                return nil, err
+            }
+        }
+        return out, nil
+    }
+    return nil, fmt.Errorf("resp: unexpected reply %q", line)
+}
+
+// Client runs commands over a single connection, redialling after errors.
+// It is safe for concurrent use; commands are serialised.
+type Client struct {
+    opts Options
+    mu   sync.Mutex
+    cn   *conn
+}
+
+// Dial connects to the server at the given redis:// URL.
+func Dial(rawURL string) (*Client, error) {
+    o, err := ParseURL(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    cl := &Client{opts: o}
+    if _, err := cl.Do("PING"); err != nil {
+        return nil, err
+    }
+    return cl, nil
+}
+
+// Options returns the settings the client was dialled with.
+func (cl *Client) Options() Options { return cl.opts }
+
+// Do runs one command and returns its reply. Error replies are returned as
+// an Error.
+func (cl *Client) Do(args ...string) (any, error) {
+    cl.mu.Lock()
+    defer cl.mu.Unlock()
+    if cl.cn == nil {
+        cn, err := dial(cl.opts)
+        if err != nil {
+            return nil, err
+        }
+        cl.cn = cn
+    }
+    v, err := cl.cn.do(args...)
+    if err != nil {
+        cl.cn.c.Close()
+        cl.cn = nil
+        return nil, err
+    }
+    if e, ok := v.(Error); ok {
+        return nil, e
+    }
+    return v, nil
+}
+
+// Exclusive runs fn with the connection to itself: no other caller's
+// commands come between those fn sends with do, as a transaction (WATCH,
+// MULTI, EXEC) needs. do is like Do, except that it doesn't redial; after
+// a failed round trip, every later do fails too.
+func (cl *Client) Exclusive(fn func(do func(args ...string) (any, error)) error) error {
+    cl.mu.Lock()
+    defer cl.mu.Unlock()
+    if cl.cn == nil {
+        cn, err := dial(cl.opts)
+        if err != nil {
+            return err
+        }
+        cl.cn = cn
+    }
+    return fn(func(args ...string) (any, error) {
+        if cl.cn == nil {
+            return nil, errors.New("resp: connection lost")
+        }
+        v, err := cl.cn.do(args...)
+        if err != nil {
+            cl.cn.c.Close()
+            cl.cn = nil
+            return nil, err
+        }
+        if e, ok := v.(Error); ok {
+            return nil, e
+        }
+        return v, nil
+    })
+}
+
+// Bytes runs a command with a bulk string reply. A nil reply is ErrNil.
+func (cl *Client) Bytes(args ...string) ([]byte, error) {
+    v, err := cl.Do(args...)
+    if err != nil {
+        return nil, err
+    }
+    switch v := v.(type) {
+    case []byte:
+        return v, nil
+    case string:
+        return []byte(v), nil
+    case nil:
+        return nil, ErrNil
+    }
+    return nil, fmt.Errorf("resp: %s: unexpected reply %T", args[0], v)
+}
+
+// Int runs a command with an integer reply.
+func (cl *Client) Int(args ...string) (int64, error) {
+    v, err := cl.Do(args...)
+    if err != nil {
+        return 0, err
+    }
+    n, ok := v.(int64)
+    if !ok {
+        return 0, fmt.Errorf("resp: %s: unexpected reply %T", args[0], v)
+    }
+    return n, nil
+}
+
+// Close closes the connection.
+func (cl *Client) Close() error {
+    cl.mu.Lock()
+    defer cl.mu.Unlock()
+    if cl.cn == nil {
+        return nil
+    }
+    err := cl.cn.c.Close()
+    cl.cn = nil
+    return err
+}
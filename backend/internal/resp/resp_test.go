@@ -0,0 +1,141 @@
+package resp
+
+import (
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    "slideflow/internal/resp/resptest"
+)
+
+func TestParseURL(t *testing.T) {
+    tests := []struct {
+        in   string
+        want Options
+    }{
+        {"redis://cache", Options{Addr: "cache:6379"}},
+        {"redis://:secret@cache:6380/2", Options{Addr: "cache:6380", Password: "secret", DB: 2}},
+        {"redis://app:secret@[::1]:6379", Options{Addr: "[::1]:6379", Username: "app", Password: "secret"}},
+    }
+    for _, tt := range tests {
+        got, err := ParseURL(tt.in)
+        if err != nil {
+            t.Errorf("ParseURL(%q): %v", tt.in, err)
+        } else if got != tt.want {
+            t.Errorf("ParseURL(%q) = %+v, want %+v", tt.in, got, tt.want)
+        }
+    }
+    for _, in := range []string{"http://cache", "redis://cache/x"} {
+        if _, err := ParseURL(in); err == nil {
+            t.Errorf("ParseURL(%q) succeeded", in)
+        }
+    }
+}
+
+func TestClient(t *testing.T) {
+    srv := resptest.NewServer(t)
+    srv.Password = "secret"
+    if _, err := Dial("redis://" + srv.Addr()); err == nil {
+        t.Fatal("Dial without the password succeeded")
+    }
+    cl, err := Dial(srv.URL() + "/3")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer cl.Close()
+
+    if _, err := cl.Do("HSET", "h", "k", "v\r\nwith newline"); err != nil {
+        t.Fatal(err)
+    }
+    if b, err := cl.Bytes("HGET", "h", "k"); err != nil || string(b) != "v\r\nwith newline" {
+        t.Errorf("HGET = %q, %v", b, err)
+    }
+    if _, err := cl.Bytes("HGET", "h", "missing"); !errors.Is(err, ErrNil) {
+        t.Errorf("HGET of a missing field: %v, want ErrNil", err)
+    }
+    if n, err := cl.Int("INCR", "n"); err != nil || n != 1 {
+        t.Errorf("INCR = %d, %v", n, err)
+    }
+    var e Error
+    if _, err := cl.Do("NOSUCH"); !errors.As(err, &e) {
+        t.Errorf("unknown command: %v, want an Error", err)
+    }
+
+    // The client redials, authenticating again, after losing its
+    // connection.
+    srv.DropConnections()
+    cl.Do("PING") // may see the dead connection
+    if n, err := cl.Int("INCR", "n"); err != nil || n != 2 {
+        t.Errorf("INCR after reconnecting = %d, %v", n, err)
+    }
+    cmds := strings.Join(srv.Commands(), " ")
+    if !strings.HasPrefix(cmds, "PING AUTH SELECT PING ") || !strings.HasSuffix(cmds, " AUTH SELECT INCR") {
+        t.Errorf("commands %s: want AUTH and SELECT from the URL on each connection", cmds)
+    }
+}
+
+func TestPubSubReconnect(t *testing.T) {
+    srv := resptest.NewServer(t)
+    cl, err := Dial(srv.URL())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer cl.Close()
+
+    msgs := make(chan string, 16)
+    ps := NewPubSub(cl.Options(), func(channel string, payload []byte) {
+        msgs <- channel + ":" + string(payload)
+    })
+    defer ps.Close()
+    ps.Subscribe("a", "b")
+
+    // publishes until one gets through, since the subscription may not
+    // be in place yet
+    deliver := func(channel, payload string) {
+        t.Helper()
+        deadline := time.Now().Add(5 * time.Second)
+        for time.Now().Before(deadline) {
+            if srv.Subscribers(channel) > 0 {
+                if _, err := cl.Do("PUBLISH", channel, payload); err == nil {
+                    break
+                }
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+        select {
+        case got := <-msgs:
+            if want := channel + ":" + payload; got != want {
+                t.Fatalf("got %q, want %q", got, want)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatalf("%s:%s not delivered", channel, payload)
+        }
+    }
+    deliver("a", "1")
+    deliver("b", "2")
+
+    srv.DropConnections()
+    deliver("a", "after reconnect")
+    deliver("b", "after reconnect")
+    if !ps.Connected() {
+        t.Error("not connected after reconnecting")
+    }
+
+    ps.Unsubscribe("a")
+    deadline := time.Now().Add(5 * time.Second)
+    for srv.Subscribers("a") > 0 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    cl.Do("PUBLISH", "a", "unwanted")
+    // and it stays unsubscribed after another reconnect
+    srv.DropConnections()
+    deliver("b", "3")
+    cl.Do("PUBLISH", "a", "unwanted")
+    deliver("b", "4")
+
+    ps.Close()
+    if ps.Connected() {
+        t.Error("connected after Close")
+    }
+}
@@ -0,0 +1,361 @@
+// Package resptest runs an in-process stand-in for a Redis server, for
+// testing code built on package resp. It keeps everything in memory and
+// knows only the commands the room store and broker send.
+package resptest
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// Server is a fake Redis server listening on a loopback port.
+type Server struct {
+    l net.Listener
+    // Password, if set before the first connection, is required by AUTH.
+    Password string
+
+    mu       sync.Mutex
+    conns    map[*conn]bool
+    hashes   map[string]map[string]string
+    counts   map[string]int64
+    subs     map[string]map[*conn]bool
+    cmds     []string
+    versions map[string]uint64 // writes to each key, for WATCH
+}
+
+type conn struct {
+    c    net.Conn
+    wmu  sync.Mutex
+    auth bool
+
+    // transaction state: the versions of the keys under WATCH, and the
+    // commands queued since MULTI
+    watched map[string]uint64
+    multi   bool
+    queued  [][]string
+}
+
+// NewServer starts a server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+    t.Helper()
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := &Server{
+        l:        l,
+        conns:    map[*conn]bool{},
+        hashes:   map[string]map[string]string{},
+        counts:   map[string]int64{},
+        subs:     map[string]map[*conn]bool{},
+        versions: map[string]uint64{},
+    }
+    go s.accept()
+    t.Cleanup(s.Close)
+    return s
+}
+
+// Addr is the host:port the server listens on.
+func (s *Server) Addr() string { return s.l.Addr().String() }
+
+// URL is a redis:// URL for the server, with the password if one is set.
+func (s *Server) URL() string {
+    if s.Password != "" {
+        return "redis://:" + s.Password + "@" + s.Addr()
+    }
+    return "redis://" + s.Addr()
+}
+
+// Commands returns the names of the commands received so far, in order.
+func (s *Server) Commands() []string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]string(nil), s.cmds...)
+}
+
+// Subscribers returns how many connections are subscribed to channel.
+func (s *Server) Subscribers(channel string) int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return len(s.subs[channel])
+}
+
+// DropConnections closes every client connection, as a restarting server
+// would, and keeps listening. Data is kept.
+func (s *Server) DropConnections() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for cn := range s.conns {
+        cn.c.Close()
+        s.forget(cn)
+    }
+}
+
+// forget drops a closed connection's state. Callers hold s.mu.
+func (s *Server) forget(cn *conn) {
+    delete(s.conns, cn)
+    for _, subs := range s.subs {
+        delete(subs, cn)
+    }
+}
+
+// Close stops listening and closes every connection.
+func (s *Server) Close() {
+    s.l.Close()
+    s.DropConnections()
+}
+
+func (s *Server) accept() {
+    for {
+        c, err := s.l.Accept()
+        if err != nil {
+            return
+        }
+        cn := &conn{c: c}
+        s.mu.Lock()
+        s.conns[cn] = true
+        s.mu.Unlock()
+        go s.serve(cn)
+    }
+}
+
+func (s *Server) serve(cn *conn) {
+    defer func() {
+        s.mu.Lock()
+        s.forget(cn)
+        s.mu.Unlock()
+        cn.c.Close()
+    }()
+    r := bufio.NewReader(cn.c)
+    for {
+        args, err := readCommand(r)
+        if err != nil {
+            return
+        }
+        if len(args) == 0 {
+            continue
+        }
+        s.mu.Lock()
+        s.cmds = append(s.cmds, strings.ToUpper(args[0]))
+        reply := s.exec(cn, args)
+        s.mu.Unlock()
+        cn.write(reply)
+    }
+}
+
+// exec runs one command and returns its reply. Callers hold s.mu.
+func (s *Server) exec(cn *conn, args []string) string {
+    cmd := strings.ToUpper(args[0])
+    if want, ok := arity[cmd]; !ok {
+        return "-ERR unknown command '" + args[0] + "'\r\n"
+    } else if want > 0 && len(args) != want || want < 0 && len(args) < -want {
+        return "-ERR wrong number of arguments for '" + args[0] + "'\r\n"
+    }
+    if s.Password != "" && !cn.auth && cmd != "AUTH" {
+        return "-NOAUTH Authentication required.\r\n"
+    }
+    if cn.multi {
+        switch cmd {
+        case "EXEC", "DISCARD":
+        case "MULTI", "WATCH":
+            return "-ERR " + cmd + " inside MULTI is not allowed\r\n"
+        default:
+            cn.queued = append(cn.queued, args)
+            return "+QUEUED\r\n"
+        }
+    }
+    switch cmd {
+    case "WATCH":
+        if cn.watched == nil {
+            cn.watched = map[string]uint64{}
+        }
+        for _, k := range args[1:] {
+            cn.watched[k] = s.versions[k]
+        }
+        return "+OK\r\n"
+    case "UNWATCH":
+        cn.watched = nil
+        return "+OK\r\n"
+    case "MULTI":
+        cn.multi = true
+        return "+OK\r\n"
+    case "DISCARD", "EXEC":
+        if !cn.multi {
+            return "-ERR " + cmd + " without MULTI\r\n"
+        }
+        queued, watched := cn.queued, cn.watched
+        cn.multi, cn.queued, cn.watched = false, nil, nil
+        if cmd == "DISCARD" {
+            return "+OK\r\n"
+        }
+        for k, v := range watched {
+            if s.versions[k] != v {
+                return "*-1\r\n"
+            }
+        }
+        out := fmt.Sprintf("*%d\r\n", len(queued))
+        for _, q := range queued {
+            out += s.exec(cn, q)
+        }
+        return out
+    case "AUTH":
+        if args[len(args)-1] != s.Password {
+            return "-WRONGPASS invalid password\r\n"
+        }
+        cn.auth = true
+        return "+OK\r\n"
+    case "PING":
+        return "+PONG\r\n"
+    case "SELECT":
+        return "+OK\r\n"
+    case "HSET":
+        h := s.hashes[args[1]]
+        if h == nil {
+            h = map[string]string{}
+            s.hashes[args[1]] = h
+        }
+        _, existed := h[args[2]]
+        h[args[2]] = args[3]
+        s.versions[args[1]]++
+        if existed {
+            return ":0\r\n"
+        }
+        return ":1\r\n"
+    case "HGET":
+        v, ok := s.hashes[args[1]][args[2]]
+        if !ok {
+            return "$-1\r\n"
+        }
+        return bulk(v)
+    case "HDEL":
+        h := s.hashes[args[1]]
+        if _, ok := h[args[2]]; !ok {
+            return ":0\r\n"
+        }
+        delete(h, args[2])
+        s.versions[args[1]]++
+        if len(h) == 0 {
+            delete(s.hashes, args[1])
+        }
+        return ":1\r\n"
+    case "HVALS":
+        h := s.hashes[args[1]]
+        out := fmt.Sprintf("*%d\r\n", len(h))
+        for _, v := range h {
+            out += bulk(v)
+        }
+        return out
+    case "INCR":
+        s.counts[args[1]]++
+        s.versions[args[1]]++
+        return fmt.Sprintf(":%d\r\n", s.counts[args[1]])
+    case "DEL":
+        n := 0
+        for _, k := range args[1:] {
+            if _, ok := s.counts[k]; ok {
+                delete(s.counts, k)
+                n++
+            }
+            if _, ok := s.hashes[k]; ok {
+                delete(s.hashes, k)
+                n++
+            }
+            s.versions[k]++
+        }
+        return fmt.Sprintf(":%d\r\n", n)
+    case "PUBLISH":
+        msg := "*3\r\n" + bulk("message") + bulk(args[1]) + bulk(args[2])
+        for sub := range s.subs[args[1]] {
+            sub.write(msg)
+        }
+        return fmt.Sprintf(":%d\r\n", len(s.subs[args[1]]))
+    case "SUBSCRIBE", "UNSUBSCRIBE":
+        var out string
+        for _, ch := range args[1:] {
+            if cmd == "SUBSCRIBE" {
+                if s.subs[ch] == nil {
+                    s.subs[ch] = map[*conn]bool{}
+                }
+                s.subs[ch][cn] = true
+            } else {
+                delete(s.subs[ch], cn)
+            }
+            out += "*3\r\n" + bulk(strings.ToLower(cmd)) + bulk(ch) + ":1\r\n"
+        }
+        return out
+    }
+    panic("resptest: no handler for " + cmd)
+}
+
+// arity is each known command's argument count including its name;
+// negative means at least that many.
+var arity = map[string]int{
+    "AUTH":        -2,
+    "PING":        1,
+    "SELECT":      2,
+    "HSET":        4,
+    "HGET":        3,
+    "HDEL":        3,
+    "HVALS":       2,
+    "INCR":        2,
+    "DEL":         -2,
+    "PUBLISH":     3,
+    "SUBSCRIBE":   -2,
+    "UNSUBSCRIBE": -2,
+    "WATCH":       -2,
+    "UNWATCH":     1,
+    "MULTI":       1,
+    "EXEC":        1,
+    "DISCARD":     1,
+}
+
+func (cn *conn) write(s string) {
+    cn.wmu.Lock()
+    defer cn.wmu.Unlock()
+    io.WriteString(cn.c, s)
+}
+
+func bulk(s string) string { return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n" }
+
+// readCommand reads one command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+    n, err := readHeader(r, '*')
+    if err != nil {
+        return nil, err
+    }
+    args := make([]string, n)
+    for i := range args {
+        size, err := readHeader(r, '$')
+        if err != nil {
+            return nil, err
+        }
+        b := make([]byte, size+2)
+        if _, err := io.ReadFull(r, b); err != nil {
+            return nil, err
+        }
+        args[i] = string(b[:size])
+    }
+    return args, nil
+}
+
+func readHeader(r *bufio.Reader, kind byte) (int, error) {
+    line, err := r.ReadString('\n')
+    if err != nil {
+        return 0, err
+    }
+    line = strings.TrimSuffix(line, "\r\n")
+    if line == "" || line[0] != kind {
+        return 0, fmt.Errorf("resptest: want %c, got %q", kind, line)
+    }
+    n, err := strconv.Atoi(line[1:])
+    if err != nil || n < 0 {
+        return 0, fmt.Errorf("resptest: bad length %q", line)
+    }
+    return n, nil
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// File is a RoomStore backed by a single JSON document on disk. Every write
+// rewrites the file atomically (temp file + rename), which is plenty for the
+// handful of rooms a deployment holds at once.
+type File struct {
+    path string
+    mu   sync.Mutex
+    mem  *Memory
+}
+
+// OpenFile loads path if it exists, creating parent directories as needed.
+func OpenFile(path string) (*File, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return nil, fmt.Errorf("store: %w", err)
+    }
+    f := &File{path: path, mem: NewMemory()}
+    b, err := os.ReadFile(path)
+    switch {
+    case errors.Is(err, os.ErrNotExist):
+        return f, nil
+    case err != nil:
+        return nil, fmt.Errorf("store: %w", err)
+    }
+    var rooms []Room
+    if err := json.Unmarshal(b, &rooms); err != nil {
+        return nil, fmt.Errorf("store: decode %s: %w", path, err)
+    }
+    for _, r := range rooms {
+        f.mem.rooms[r.ID] = r
+    }
+    return f, nil
+}
+
+func (f *File) Get(id string) (Room, error) { return f.mem.Get(id) }
+
+func (f *File) List() ([]Room, error) { return f.mem.List() }
+
+// The review queue is kept in memory only: it is short-lived, and
+// writing the whole file for every held comment would be wasteful.
+
+func (f *File) Hold(room, id string, data []byte) error { return f.mem.Hold(room, id, data) }
+
+func (f *File) Held(room string) ([][]byte, error) { return f.mem.Held(room) }
+
+func (f *File) Release(room, id string) ([]byte, error) { return f.mem.Release(room, id) }
+
+func (f *File) Put(r Room) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.mem.Put(r)
+    return f.flush()
+}
+
+func (f *File) Update(id string, change func(r *Room) error) (Room, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    r, err := f.mem.Update(id, change)
+    if err != nil {
+        return Room{}, err
+    }
+    return r, f.flush()
+}
+
+func (f *File) Delete(id string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.mem.Delete(id)
+    return f.flush()
+}
+
+// Ping checks that the file's directory is still there; a write can fail
+// for other reasons, which saves report as they happen.
+func (f *File) Ping() error {
+    if _, err := os.Stat(filepath.Dir(f.path)); err != nil {
+        return fmt.Errorf("store: %w", err)
+    }
+    return nil
+}
+
+func (f *File) Close() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.flush()
+}
+
+// flush writes the whole store to disk. Callers hold f.mu.
+func (f *File) flush() error {
+    rooms, _ := f.mem.List()
+    b, err := json.MarshalIndent(rooms, "", "  ")
+    if err != nil {
+        return fmt.Errorf("store: encode: %w", err)
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(f.path), ".rooms-*.json")
+    if err != nil {
+        return fmt.Errorf("store: %w", err)
+    }
+    if _, err := tmp.Write(b); err != nil {
+        tmp.Close()
+        os.Remove(tmp.Name())
+        return fmt.Errorf("store: %w", err)
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        os.Remove(tmp.Name())
+        return fmt.Errorf("store: %w", err)
+    }
+    if err := tmp.Close(); err != nil {
+        os.Remove(tmp.Name())
+        return fmt.Errorf("store: %w", err)
+    }
+    if err := os.Rename(tmp.Name(), f.path); err != nil {
+        os.Remove(tmp.Name())
+        return fmt.Errorf("store: %w", err)
+    }
+    return nil
+}
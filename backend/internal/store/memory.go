@@ -0,0 +1,110 @@
+package store
+
+import (
+    "slices"
+    "sync"
+)
+
+// Memory is a RoomStore that keeps everything in process memory.
+type Memory struct {
+    mu    sync.RWMutex
+    rooms map[string]Room
+    held  map[string]map[string][]byte
+}
+
+func NewMemory() *Memory {
+    return &Memory{rooms: make(map[string]Room), held: make(map[string]map[string][]byte)}
+}
+
+func (m *Memory) Get(id string) (Room, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    r, ok := m.rooms[id]
+    if !ok {
+        return Room{}, ErrNotFound
+    }
+    return r, nil
+}
+
+func (m *Memory) Put(r Room) error {
+    m.mu.Lock()
+    m.rooms[r.ID] = r
+    m.mu.Unlock()
+    return nil
+}
+
+func (m *Memory) Update(id string, change func(r *Room) error) (Room, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    r, ok := m.rooms[id]
+    if !ok {
+        return Room{}, ErrNotFound
+    }
+    // change gets its own slices, so a failed change leaves no trace
+    r.Settings.AllowedStyles = slices.Clone(r.Settings.AllowedStyles)
+    r.NGRules = slices.Clone(r.NGRules)
+    r.Bans = slices.Clone(r.Bans)
+    if err := change(&r); err != nil {
+        return Room{}, err
+    }
+    m.rooms[id] = r
+    return r, nil
+}
+
+func (m *Memory) Delete(id string) error {
+    m.mu.Lock()
+    delete(m.rooms, id)
+    delete(m.held, id)
+    m.mu.Unlock()
+    return nil
+}
+
+func (m *Memory) List() ([]Room, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    out := make([]Room, 0, len(m.rooms))
+    for _, r := range m.rooms {
+        out = append(out, r)
+    }
+    return out, nil
+}
+
+func (m *Memory) Hold(room, id string, data []byte) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    q := m.held[room]
+    if q == nil {
+        q = make(map[string][]byte)
+        m.held[room] = q
+    }
+    q[id] = slices.Clone(data)
+    return nil
+}
+
+func (m *Memory) Held(room string) ([][]byte, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    out := make([][]byte, 0, len(m.held[room]))
+    for _, b := range m.held[room] {
+        out = append(out, b)
+    }
+    return out, nil
+}
+
+func (m *Memory) Release(room, id string) ([]byte, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    b, ok := m.held[room][id]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    delete(m.held[room], id)
+    if len(m.held[room]) == 0 {
+        delete(m.held, room)
+    }
+    return b, nil
+}
+
+func (m *Memory) Ping() error { return nil }
+
+func (m *Memory) Close() error { return nil }
@@ -0,0 +1,206 @@
+package store
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+
+    "slideflow/internal/resp"
+)
+
+// redisKey is the hash holding every room, keyed by ID.
+const redisKey = "slideflow:rooms"
+
+// heldKey is the hash holding a room's review queue, keyed by entry ID.
+func heldKey(room string) string { return "slideflow:held:" + room }
+
+// Redis is a RoomStore in a Redis hash, for running several replicas
+// against the same rooms.
+type Redis struct {
+    cl *resp.Client
+}
+
+// OpenRedis connects to the server at a redis:// URL.
+func OpenRedis(rawURL string) (*Redis, error) {
+    cl, err := resp.Dial(rawURL)
+    if err != nil {
+        return nil, fmt.Errorf("store: %w", err)
+    }
+    return &Redis{cl: cl}, nil
+}
+
+func (s *Redis) Get(id string) (Room, error) {
+    b, err := s.cl.Bytes("HGET", redisKey, id)
+    if errors.Is(err, resp.ErrNil) {
+        return Room{}, ErrNotFound
+    }
+    if err != nil {
+        return Room{}, fmt.Errorf("store: %w", err)
+    }
+    var r Room
+    if err := json.Unmarshal(b, &r); err != nil {
+        return Room{}, fmt.Errorf("store: decode %s: %w", id, err)
+    }
+    return r, nil
+}
+
+func (s *Redis) Put(r Room) error {
+    b, err := json.Marshal(r)
+    if err != nil {
+        return fmt.Errorf("store: encode: %w", err)
+    }
+    if _, err := s.cl.Do("HSET", redisKey, r.ID, string(b)); err != nil {
+        return fmt.Errorf("store: %w", err)
+    }
+    return nil
+}
+
+// maxUpdateTries bounds how often Update starts over after losing a race
+// with another writer.
+const maxUpdateTries = 10
+
+// Update reads the room under WATCH and writes it back in a MULTI/EXEC
+// transaction, which Redis refuses if any room changed in between; then
+// it tries again with the newer copy.
+func (s *Redis) Update(id string, change func(r *Room) error) (Room, error) {
+    for range maxUpdateTries {
+        r, ok, err := s.tryUpdate(id, change)
+        if err != nil || ok {
+            return r, err
+        }
+    }
+    return Room{}, fmt.Errorf("store: update %s: too many concurrent writes", id)
+}
+
+// tryUpdate makes one attempt at Update. ok is false if another writer got
+// in first and nothing was saved.
+func (s *Redis) tryUpdate(id string, change func(r *Room) error) (r Room, ok bool, err error) {
+    err = s.cl.Exclusive(func(do func(args ...string) (any, error)) error {
+        if _, err := do("WATCH", redisKey); err != nil {
+            return fmt.Errorf("store: %w", err)
+        }
+        v, err := do("HGET", redisKey, id)
+        if err != nil {
+            do("UNWATCH")
+            return fmt.Errorf("store: %w", err)
+        }
+        b, err := updated(v, id, &r, change)
+        if err != nil {
+            do("UNWATCH")
+            return err
+        }
+        if _, err := do("MULTI"); err != nil {
+            return fmt.Errorf("store: %w", err)
+        }
+        if _, err := do("HSET", redisKey, id, string(b)); err != nil {
+            do("DISCARD")
+            return fmt.Errorf("store: %w", err)
+        }
+        v, err = do("EXEC")
+        if err != nil {
+            return fmt.Errorf("store: %w", err)
+        }
+        ok = v != nil
+        return nil
+    })
+    return r, ok, err
+}
+
+// updated decodes the room from HGET's reply v into r, applies change and
+// returns the encoded result.
+func updated(v any, id string, r *Room, change func(r *Room) error) ([]byte, error) {
+    b, ok := v.([]byte)
+    if !ok {
+        return nil, ErrNotFound
+    }
+    *r = Room{}
+    if err := json.Unmarshal(b, r); err != nil {
+        return nil, fmt.Errorf("store: decode %s: %w", id, err)
+    }
+    if err := change(r); err != nil {
+        return nil, err
+    }
+    b, err := json.Marshal(r)
+    if err != nil {
+        return nil, fmt.Errorf("store: encode: %w", err)
+    }
+    return b, nil
+}
+
+func (s *Redis) Delete(id string) error {
+    if _, err := s.cl.Do("HDEL", redisKey, id); err != nil {
+        return fmt.Errorf("store: %w", err)
+    }
+    if _, err := s.cl.Do("DEL", heldKey(id)); err != nil {
+        return fmt.Errorf("store: %w", err)
+    }
+    return nil
+}
+
+func (s *Redis) List() ([]Room, error) {
+    v, err := s.cl.Do("HVALS", redisKey)
+    if err != nil {
+        return nil, fmt.Errorf("store: %w", err)
+    }
+    vals, _ := v.([]any)
+    out := make([]Room, 0, len(vals))
+    for _, val := range vals {
+        b, _ := val.([]byte)
+        var r Room
+        if err := json.Unmarshal(b, &r); err != nil {
+            return nil, fmt.Errorf("store: decode: %w", err)
+        }
+        out = append(out, r)
+    }
+    return out, nil
+}
+
+func (s *Redis) Hold(room, id string, data []byte) error {
+    if _, err := s.cl.Do("HSET", heldKey(room), id, string(data)); err != nil {
+        return fmt.Errorf("store: %w", err)
+    }
+    return nil
+}
+
+func (s *Redis) Held(room string) ([][]byte, error) {
+    v, err := s.cl.Do("HVALS", heldKey(room))
+    if err != nil {
+        return nil, fmt.Errorf("store: %w", err)
+    }
+    vals, _ := v.([]any)
+    out := make([][]byte, 0, len(vals))
+    for _, val := range vals {
+        b, _ := val.([]byte)
+        out = append(out, b)
+    }
+    return out, nil
+}
+
+// Release reads the entry, then deletes it; of several replicas racing
+// for the same entry, only the one whose HDEL removed it gets it.
+func (s *Redis) Release(room, id string) ([]byte, error) {
+    b, err := s.cl.Bytes("HGET", heldKey(room), id)
+    if errors.Is(err, resp.ErrNil) {
+        return nil, ErrNotFound
+    }
+    if err != nil {
+        return nil, fmt.Errorf("store: %w", err)
+    }
+    n, err := s.cl.Int("HDEL", heldKey(room), id)
+    if err != nil {
+        return nil, fmt.Errorf("store: %w", err)
+    }
+    if n == 0 {
+        return nil, ErrNotFound
+    }
+    return b, nil
+}
+
+func (s *Redis) Ping() error {
+    if _, err := s.cl.Do("PING"); err != nil {
+        return fmt.Errorf("store: %w", err)
+    }
+    return nil
+}
+
+func (s *Redis) Close() error { return s.cl.Close() }
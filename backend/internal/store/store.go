@@ -0,0 +1,90 @@
+// Package store persists room metadata so rooms survive restarts.
+package store
+
+import (
+    "errors"
+    "time"
+
+    "slideflow/internal/ngword"
+)
+
+// ErrNotFound is returned when a room does not exist in the store.
+var ErrNotFound = errors.New("store: room not found")
+
+// Settings are the per-room posting rules chosen by the presenter.
+type Settings struct {
+    MaxTextLen     int  `json:"maxTextLen"`
+    MaxHandleLen   int  `json:"maxHandleLen"`
+    AllowAnonymous bool `json:"allowAnonymous"`
+    // CooldownMs is the minimum gap between posts per participant when
+    // slow mode is off.
+    CooldownMs int64 `json:"cooldownMs"`
+    // AllowedStyles lists the style commands posters may use
+    // ("color", "size", "position").
+    AllowedStyles []string `json:"allowedStyles"`
+    // NGMode selects which NG word list applies to the room.
+    NGMode string `json:"ngMode"`
+    // NGGlobalAction is the action for hits on the server-wide NG list
+    // ("reject", "mask" or "hold"); room rules carry their own.
+    NGGlobalAction string `json:"ngGlobalAction,omitempty"`
+    // ReviewMode holds every comment for a moderator to approve before it
+    // is shown.
+    ReviewMode bool `json:"reviewMode,omitempty"`
+}
+
+// Ban blocks a participant from posting in a room. A nil Until is a ban for
+// the life of the room; otherwise it is a mute that lapses at Until.
+type Ban struct {
+    ID string `json:"id"`
+    // Kind says what Value is: "identity" (ip|handle), "ip" or
+    // "participant" (the post form's cookie).
+    Kind  string `json:"kind"`
+    Value string `json:"value"`
+    // Label is a human-readable hint, e.g. the handle the ban was made from.
+    Label     string     `json:"label,omitempty"`
+    Reason    string     `json:"reason,omitempty"`
+    Until     *time.Time `json:"until,omitempty"`
+    CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Room is the persisted part of a room. Live state such as the WebSocket hub
+// is rebuilt from it on demand.
+type Room struct {
+    ID         string        `json:"id"`
+    AdminToken string        `json:"adminToken"`
+    Paused     bool          `json:"paused"`
+    SlowModeMs int64         `json:"slowModeMs"`
+    Settings   Settings      `json:"settings"`
+    NGRules    []ngword.Rule `json:"ngRules,omitempty"`
+    Bans       []Ban         `json:"bans,omitempty"`
+    CreatedAt  time.Time     `json:"createdAt"`
+}
+
+// RoomStore is the backend behind app.Server's room registry.
+type RoomStore interface {
+    Get(id string) (Room, error)
+    Put(r Room) error
+    // Update applies change to the stored room and saves the result,
+    // without losing a write made by anyone else in between, and returns
+    // it. change sees the latest copy and may be called more than once;
+    // it must not use the store. An error from change abandons the update
+    // and is returned as is. Update fails with ErrNotFound if the room
+    // doesn't exist.
+    Update(id string, change func(r *Room) error) (Room, error)
+    // Delete removes the room and its review queue.
+    Delete(id string) error
+    List() ([]Room, error)
+    // Hold, Held and Release keep each room's queue of comments awaiting
+    // review, so that any replica can decide on them. Entries are opaque
+    // to the store and keyed by ID within the room. Hold adds or replaces
+    // one; Held returns them all, in no particular order.
+    Hold(room, id string, data []byte) error
+    Held(room string) ([][]byte, error)
+    // Release removes an entry and returns it. It fails with ErrNotFound
+    // if the entry isn't there, including when another caller released
+    // it first, so only one moderator's decision takes effect.
+    Release(room, id string) ([]byte, error)
+    // Ping reports whether the backend is reachable, for readiness checks.
+    Ping() error
+    Close() error
+}
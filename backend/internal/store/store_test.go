@@ -0,0 +1,266 @@
+package store
+
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+    "slices"
+    "sync"
+    "testing"
+    "time"
+
+    "slideflow/internal/ngword"
+    "slideflow/internal/resp/resptest"
+)
+
+func TestStores(t *testing.T) {
+    stores := map[string]func(t *testing.T) RoomStore{
+        "memory": func(t *testing.T) RoomStore { return NewMemory() },
+        "file": func(t *testing.T) RoomStore {
+            f, err := OpenFile(filepath.Join(t.TempDir(), "data", "rooms.json"))
+            if err != nil {
+                t.Fatal(err)
+            }
+            return f
+        },
+        "redis": func(t *testing.T) RoomStore {
+            r, err := OpenRedis(resptest.NewServer(t).URL())
+            if err != nil {
+                t.Fatal(err)
+            }
+            return r
+        },
+    }
+    for name, open := range stores {
+        t.Run(name, func(t *testing.T) {
+            st := open(t)
+            defer st.Close()
+            testRooms(t, st)
+            testUpdate(t, st, st)
+            testHeld(t, st)
+        })
+    }
+}
+
+func testRooms(t *testing.T, st RoomStore) {
+    if err := st.Ping(); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := st.Get("nope"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("Get of a missing room: %v, want ErrNotFound", err)
+    }
+    created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+    until := created.Add(time.Hour)
+    a := Room{
+        ID:         "a",
+        AdminToken: "tok",
+        Paused:     true,
+        SlowModeMs: 1500,
+        Settings:   Settings{MaxTextLen: 100, AllowedStyles: []string{"color"}, NGMode: "both"},
+        NGRules:    []ngword.Rule{{Kind: ngword.KindWord, Pattern: "x", Action: ngword.ActionMask}},
+        Bans:       []Ban{{ID: "b1", Kind: "ip", Value: "192.0.2.1", Until: &until, CreatedAt: created}},
+        CreatedAt:  created,
+    }
+    for _, r := range []Room{a, {ID: "b", CreatedAt: created}} {
+        if err := st.Put(r); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got, err := st.Get("a")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.ID != a.ID || got.AdminToken != a.AdminToken || !got.Paused || got.SlowModeMs != 1500 ||
+        got.Settings.MaxTextLen != 100 || len(got.NGRules) != 1 || len(got.Bans) != 1 ||
+        !got.Bans[0].Until.Equal(until) || !got.CreatedAt.Equal(created) {
+        t.Errorf("Get = %+v, want %+v", got, a)
+    }
+    a.Paused = false
+    st.Put(a)
+    if got, _ := st.Get("a"); got.Paused {
+        t.Error("Put didn't replace the room")
+    }
+    list, err := st.List()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ids []string
+    for _, r := range list {
+        ids = append(ids, r.ID)
+    }
+    slices.Sort(ids)
+    if !slices.Equal(ids, []string{"a", "b"}) {
+        t.Errorf("List = %v, want a and b", ids)
+    }
+    if err := st.Delete("b"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := st.Get("b"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("Get after Delete: %v, want ErrNotFound", err)
+    }
+}
+
+// testUpdate changes one room from many goroutines at once, half through
+// st and half through other, and checks that no change was lost.
+func testUpdate(t *testing.T, st, other RoomStore) {
+    if _, err := st.Update("nope", func(r *Room) error { return nil }); !errors.Is(err, ErrNotFound) {
+        t.Errorf("Update of a missing room: %v, want ErrNotFound", err)
+    }
+    st.Put(Room{ID: "u", SlowModeMs: 1})
+    errNo := errors.New("no")
+    if _, err := st.Update("u", func(r *Room) error {
+        r.Bans = append(r.Bans, Ban{ID: "x"})
+        return errNo
+    }); err != errNo {
+        t.Errorf("Update with a failing change: %v, want its error", err)
+    }
+    if r, _ := st.Get("u"); len(r.Bans) != 0 {
+        t.Errorf("failed Update saved %+v", r.Bans)
+    }
+
+    const writers = 8
+    var wg sync.WaitGroup
+    for i := 0; i < writers; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            s := st
+            if i%2 == 1 {
+                s = other
+            }
+            _, err := s.Update("u", func(r *Room) error {
+                r.Bans = append(r.Bans, Ban{ID: fmt.Sprint(i)})
+                return nil
+            })
+            if err != nil {
+                t.Error(err)
+            }
+        }(i)
+    }
+    wg.Wait()
+    r, err := st.Get("u")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(r.Bans) != writers || r.SlowModeMs != 1 {
+        t.Errorf("after %d concurrent updates: %d bans, slow mode %d", writers, len(r.Bans), r.SlowModeMs)
+    }
+    st.Delete("u")
+}
+
+func testHeld(t *testing.T, st RoomStore) {
+    held := func(room string) []string {
+        t.Helper()
+        bs, err := st.Held(room)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var out []string
+        for _, b := range bs {
+            out = append(out, string(b))
+        }
+        slices.Sort(out)
+        return out
+    }
+    if got := held("a"); len(got) != 0 {
+        t.Errorf("Held of an empty queue = %v", got)
+    }
+    st.Hold("a", "m1", []byte("one"))
+    st.Hold("a", "m2", []byte("two"))
+    st.Hold("c", "m1", []byte("other room"))
+    if got := held("a"); !slices.Equal(got, []string{"one", "two"}) {
+        t.Errorf("Held = %v", got)
+    }
+
+    b, err := st.Release("a", "m1")
+    if err != nil || string(b) != "one" {
+        t.Fatalf("Release = %q, %v", b, err)
+    }
+    if _, err := st.Release("a", "m1"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("second Release: %v, want ErrNotFound", err)
+    }
+    if _, err := st.Release("a", "nope"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("Release of a missing entry: %v, want ErrNotFound", err)
+    }
+    if got := held("c"); !slices.Equal(got, []string{"other room"}) {
+        t.Errorf("other room's queue = %v", got)
+    }
+
+    // deleting a room drops its queue
+    st.Delete("a")
+    if got := held("a"); len(got) != 0 {
+        t.Errorf("Held after Delete = %v", got)
+    }
+}
+
+func TestFileReopen(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "rooms.json")
+    f, err := OpenFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.Put(Room{ID: "a", AdminToken: "tok"})
+    f.Close()
+    f, err = OpenFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if r, err := f.Get("a"); err != nil || r.AdminToken != "tok" {
+        t.Errorf("Get after reopening = %+v, %v", r, err)
+    }
+}
+
+// TestRedisUpdate updates a room from two connections, as two replicas
+// would, so that transactions collide and start over.
+func TestRedisUpdate(t *testing.T) {
+    srv := resptest.NewServer(t)
+    a, err := OpenRedis(srv.URL())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer a.Close()
+    b, err := OpenRedis(srv.URL())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer b.Close()
+    testUpdate(t, a, b)
+
+    // a write from elsewhere between reading and saving wins, and the
+    // change is made again on top of it
+    a.Put(Room{ID: "r"})
+    calls := 0
+    r, err := a.Update("r", func(r *Room) error {
+        calls++
+        if calls == 1 {
+            b.Put(Room{ID: "r", Paused: true})
+        }
+        r.SlowModeMs = 500
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if calls != 2 || !r.Paused || r.SlowModeMs != 500 {
+        t.Errorf("Update over a concurrent Put: %d calls, %+v", calls, r)
+    }
+    if got, _ := b.Get("r"); !got.Paused || got.SlowModeMs != 500 {
+        t.Errorf("stored %+v", got)
+    }
+}
+
+func TestRedisReconnect(t *testing.T) {
+    srv := resptest.NewServer(t)
+    st, err := OpenRedis(srv.URL())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer st.Close()
+    st.Put(Room{ID: "a"})
+    srv.DropConnections()
+    // the command on the dead connection fails; the next one redials
+    st.Ping()
+    if _, err := st.Get("a"); err != nil {
+        t.Errorf("Get after reconnecting: %v", err)
+    }
+}
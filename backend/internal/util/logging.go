@@ -0,0 +1,194 @@
+package util
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// NewLogger builds the process logger. level is debug, info (default),
+// warn or error; format is json (default) or text.
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+    var lv slog.Level
+    if level != "" {
+        if err := lv.UnmarshalText([]byte(level)); err != nil {
+            return nil, fmt.Errorf("log level %q: want debug, info, warn or error", level)
+        }
+    }
+    opts := &slog.HandlerOptions{Level: lv}
+    switch strings.ToLower(format) {
+    case "", "json":
+        return slog.New(slog.NewJSONHandler(w, opts)), nil
+    case "text":
+        return slog.New(slog.NewTextHandler(w, opts)), nil
+    }
+    return nil, fmt.Errorf("log format %q: want json or text", format)
+}
+
+type ctxKey int
+
+const (
+    requestIDKey ctxKey = iota
+    logFieldsKey
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID returns the ID Logging gave the request, or "".
+func RequestID(ctx context.Context) string {
+    id, _ := ctx.Value(requestIDKey).(string)
+    return id
+}
+
+// Logger returns the default logger, tagged with the request's ID if ctx
+// belongs to one.
+func Logger(ctx context.Context) *slog.Logger {
+    if id := RequestID(ctx); id != "" {
+        return slog.Default().With("request_id", id)
+    }
+    return slog.Default()
+}
+
+// logFields collects attributes handlers add to their request's log line.
+type logFields struct {
+    mu    sync.Mutex
+    attrs []slog.Attr
+}
+
+// LogAttrs adds attributes, such as the room or a moderation outcome, to
+// the line Logging writes for the request. Outside a request it does
+// nothing.
+func LogAttrs(ctx context.Context, attrs ...slog.Attr) {
+    f, ok := ctx.Value(logFieldsKey).(*logFields)
+    if !ok {
+        return
+    }
+    f.mu.Lock()
+    f.attrs = append(f.attrs, attrs...)
+    f.mu.Unlock()
+}
+
+// Logging gives every request an ID, echoed in the X-Request-ID response
+// header, and logs one line per request once it is done. An incoming
+// X-Request-ID (e.g. from a proxy) is kept if it looks like one.
+func Logging(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        id := r.Header.Get(RequestIDHeader)
+        if !validRequestID(id) {
+            id, _ = NewToken(8)
+        }
+        fields := &logFields{}
+        ctx := context.WithValue(r.Context(), requestIDKey, id)
+        ctx = context.WithValue(ctx, logFieldsKey, fields)
+        w.Header().Set(RequestIDHeader, id)
+        rec := NewResponseRecorder(w)
+        next.ServeHTTP(rec, r.WithContext(ctx))
+
+        attrs := []slog.Attr{
+            slog.String("request_id", id),
+            slog.String("remote", r.RemoteAddr),
+            slog.String("method", r.Method),
+            slog.String("path", r.URL.Path),
+            slog.Int("status", rec.Status()),
+            slog.Int64("bytes", rec.Bytes()),
+            slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+        }
+        fields.mu.Lock()
+        attrs = append(attrs, fields.attrs...)
+        fields.mu.Unlock()
+        level := slog.LevelInfo
+        if rec.Status() >= 500 {
+            level = slog.LevelError
+        }
+        slog.LogAttrs(ctx, level, "request", attrs...)
+    })
+}
+
+func validRequestID(id string) bool {
+    if id == "" || len(id) > 64 {
+        return false
+    }
+    for _, c := range id {
+        switch {
+        case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// ResponseRecorder remembers the status and size of a response. It passes
+// Flush and Hijack through, which SSE and WebSocket upgrades need, and
+// unwraps for http.ResponseController.
+type ResponseRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int64
+}
+
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+    return &ResponseRecorder{ResponseWriter: w}
+}
+
+func (r *ResponseRecorder) WriteHeader(code int) {
+    if r.status == 0 {
+        r.status = code
+    }
+    r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+    if r.status == 0 {
+        r.status = http.StatusOK
+    }
+    n, err := r.ResponseWriter.Write(b)
+    r.bytes += int64(n)
+    return n, err
+}
+
+func (r *ResponseRecorder) Flush() {
+    if f, ok := r.ResponseWriter.(http.Flusher); ok {
+        if r.status == 0 {
+            r.status = http.StatusOK
+        }
+        f.Flush()
+    }
+}
+
+func (r *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    h, ok := r.ResponseWriter.(http.Hijacker)
+    if !ok {
+        return nil, nil, errors.New("hijack not supported")
+    }
+    conn, rw, err := h.Hijack()
+    if err == nil && r.status == 0 {
+        // the upgrade response is written to conn, not through us
+        r.status = http.StatusSwitchingProtocols
+    }
+    return conn, rw, err
+}
+
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// Status returns the response status; 200 if nothing was written.
+func (r *ResponseRecorder) Status() int {
+    if r.status == 0 {
+        return http.StatusOK
+    }
+    return r.status
+}
+
+// Bytes returns the number of body bytes written, not counting hijacked
+// connections.
+func (r *ResponseRecorder) Bytes() int64 { return r.bytes }
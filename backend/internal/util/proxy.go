@@ -0,0 +1,298 @@
+package util
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "net/netip"
+    "strings"
+)
+
+// The forwarding header families a proxy may pass the client's details in.
+const (
+    // HeaderXForwarded is X-Forwarded-For, -Proto and -Host.
+    HeaderXForwarded = "x-forwarded"
+    // HeaderForwarded is Forwarded (RFC 7239).
+    HeaderForwarded = "forwarded"
+)
+
+// Networks is a set of address ranges.
+type Networks []netip.Prefix
+
+// ParseNetworks parses a comma-separated list of CIDRs or single
+// addresses, e.g. "10.0.0.0/8, 192.168.1.10, ::1".
+func ParseNetworks(s string) (Networks, error) {
+    var nets Networks
+    for _, f := range strings.Split(s, ",") {
+        f = strings.TrimSpace(f)
+        if f == "" {
+            continue
+        }
+        if strings.Contains(f, "/") {
+            pfx, err := netip.ParsePrefix(f)
+            if err != nil {
+                return nil, fmt.Errorf("%q: %w", f, err)
+            }
+            nets = append(nets, pfx.Masked())
+            continue
+        }
+        ip, err := netip.ParseAddr(f)
+        if err != nil {
+            return nil, fmt.Errorf("%q: %w", f, err)
+        }
+        ip = ip.Unmap()
+        nets = append(nets, netip.PrefixFrom(ip, ip.BitLen()))
+    }
+    return nets, nil
+}
+
+// Contains reports whether ip is in one of the ranges.
+func (n Networks) Contains(ip netip.Addr) bool {
+    if !ip.IsValid() {
+        return false
+    }
+    ip = ip.Unmap()
+    for _, pfx := range n {
+        if pfx.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// Proxies is the set of reverse proxies whose forwarding headers are
+// believed. Headers on requests from anywhere else are ignored, so clients
+// cannot spoof their address or the URLs the server hands out.
+//
+// Only the family the proxies are configured to set is read: a proxy that
+// appends to X-Forwarded-For passes a client's own Forwarded header along
+// untouched, and the other way round. Within it, each trusted proxy is
+// expected to append its entry, so values are read from the right and
+// anything to the left of the proxy the client connected to is ignored.
+type Proxies struct {
+    nets   Networks
+    header string
+}
+
+// ParseProxies parses the proxies' addresses, as for ParseNetworks, and
+// their header family, HeaderXForwarded or HeaderForwarded. An empty list
+// trusts no one.
+func ParseProxies(s, header string) (*Proxies, error) {
+    if header != HeaderXForwarded && header != HeaderForwarded {
+        return nil, fmt.Errorf("proxy header %q: want %s or %s", header, HeaderXForwarded, HeaderForwarded)
+    }
+    nets, err := ParseNetworks(s)
+    if err != nil {
+        return nil, fmt.Errorf("trusted proxy %w", err)
+    }
+    return &Proxies{nets: nets, header: header}, nil
+}
+
+// Trusted reports whether ip belongs to a trusted proxy.
+func (p *Proxies) Trusted(ip netip.Addr) bool {
+    return p != nil && p.nets.Contains(ip)
+}
+
+// ClientIP returns the address of the client that sent r. Behind trusted
+// proxies it is the rightmost hop in the forwarding header that is not
+// itself a trusted proxy; earlier hops are whatever the client claimed.
+func (p *Proxies) ClientIP(r *http.Request) string {
+    client, _, ok := p.trace(r)
+    if !ok {
+        return r.RemoteAddr
+    }
+    return client.String()
+}
+
+// trace walks the forwarded-for chain from our end. It returns the client
+// as the outermost trusted proxy saw it and how many trusted proxies the
+// request came through, which is zero when it came straight from the
+// client.
+func (p *Proxies) trace(r *http.Request) (client netip.Addr, hops int, ok bool) {
+    remote, ok := parseNode(r.RemoteAddr)
+    if !ok {
+        return netip.Addr{}, 0, false
+    }
+    if !p.Trusted(remote) {
+        return remote, 0, true
+    }
+    client, hops = remote, 1
+    fors := p.values(r, "for")
+    for i := len(fors) - 1; i >= 0; i-- {
+        ip, ok := parseNode(fors[i])
+        if !ok {
+            // "unknown", an obfuscated name or garbage: the proxy that
+            // added it is as close to the client as we can get
+            break
+        }
+        client = ip
+        if !p.Trusted(ip) {
+            break
+        }
+        hops++
+    }
+    return client, hops, true
+}
+
+// outerValue returns the proto or host added by the outermost of hops
+// trusted proxies: the hops-th value from the right. Values left of it
+// came from the client; if there are too few, a proxy didn't add one and
+// none is used.
+func (p *Proxies) outerValue(r *http.Request, param string, hops int) string {
+    if hops == 0 {
+        return ""
+    }
+    vals := p.values(r, param)
+    if i := len(vals) - hops; i >= 0 {
+        return vals[i]
+    }
+    return ""
+}
+
+// ClientIdentity identifies a poster for rate limiting and moderation as
+// "ip|handle".
+func (p *Proxies) ClientIdentity(r *http.Request, handle string) string {
+    handle = strings.ToLower(strings.TrimSpace(handle))
+    return p.ClientIP(r) + "|" + handle
+}
+
+// Scheme returns "https" or "http" as the client sees the server.
+func (p *Proxies) Scheme(r *http.Request) string {
+    if r.TLS != nil {
+        return "https"
+    }
+    if _, hops, ok := p.trace(r); ok {
+        proto := strings.ToLower(p.outerValue(r, "proto", hops))
+        if proto == "https" || proto == "http" {
+            return proto
+        }
+    }
+    return "http"
+}
+
+// Host returns the host (and port) the client addressed.
+func (p *Proxies) Host(r *http.Request) string {
+    if _, hops, ok := p.trace(r); ok {
+        if host := p.outerValue(r, "host", hops); validHost(host) {
+            return host
+        }
+    }
+    return r.Host
+}
+
+// BaseURL is the scheme and host the client used, e.g. for links and QR
+// codes pointing back at this server.
+func (p *Proxies) BaseURL(r *http.Request) string {
+    return p.Scheme(r) + "://" + p.Host(r)
+}
+
+// parseNode parses an address as found in RemoteAddr, X-Forwarded-For or a
+// Forwarded for= value: a bare IPv4 or IPv6 address, optionally quoted,
+// bracketed and/or followed by a port.
+func parseNode(s string) (netip.Addr, bool) {
+    s = strings.Trim(strings.TrimSpace(s), `"`)
+    if host, _, err := net.SplitHostPort(s); err == nil {
+        s = host
+    } else {
+        s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+    }
+    // drop an IPv6 zone; it means nothing off the host
+    if i := strings.IndexByte(s, '%'); i >= 0 {
+        s = s[:i]
+    }
+    ip, err := netip.ParseAddr(s)
+    if err != nil {
+        return netip.Addr{}, false
+    }
+    return ip.Unmap(), true
+}
+
+// xForwarded names the X-Forwarded-* header for each parameter.
+var xForwarded = map[string]string{
+    "for":   "X-Forwarded-For",
+    "proto": "X-Forwarded-Proto",
+    "host":  "X-Forwarded-Host",
+}
+
+// values returns a forwarding parameter ("for", "proto" or "host") as
+// added by each proxy, nearest last, from the configured header family.
+// For Forwarded that is one per element, empty where an element lacks it.
+func (p *Proxies) values(r *http.Request, param string) []string {
+    var out []string
+    if p.header == HeaderForwarded {
+        for _, elem := range forwardedElements(r) {
+            out = append(out, elem[param])
+        }
+        return out
+    }
+    for _, line := range r.Header.Values(xForwarded[param]) {
+        for _, v := range strings.Split(line, ",") {
+            if v = strings.TrimSpace(v); v != "" {
+                out = append(out, v)
+            }
+        }
+    }
+    return out
+}
+
+// forwardedElements splits every Forwarded header into its elements, each
+// a map of lowercased parameter names to unquoted values.
+func forwardedElements(r *http.Request) []map[string]string {
+    var out []map[string]string
+    for _, line := range r.Header.Values("Forwarded") {
+        for _, elem := range splitQuoted(line, ',') {
+            params := map[string]string{}
+            for _, pair := range splitQuoted(elem, ';') {
+                k, v, ok := strings.Cut(pair, "=")
+                if !ok {
+                    continue
+                }
+                k = strings.ToLower(strings.TrimSpace(k))
+                v = strings.TrimSpace(v)
+                if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+                    v = strings.ReplaceAll(v[1:len(v)-1], `\"`, `"`)
+                }
+                params[k] = v
+            }
+            out = append(out, params)
+        }
+    }
+    return out
+}
+
+// splitQuoted splits s at sep, ignoring separators inside double quotes.
+func splitQuoted(s string, sep byte) []string {
+    var out []string
+    quoted := false
+    start := 0
+    for i := 0; i < len(s); i++ {
+        switch {
+        case s[i] == '\\' && quoted:
+            i++
+        case s[i] == '"':
+            quoted = !quoted
+        case s[i] == sep && !quoted:
+            out = append(out, s[start:i])
+            start = i + 1
+        }
+    }
+    return append(out, s[start:])
+}
+
+// validHost accepts a hostname or address with an optional port and
+// nothing else, so a header can't smuggle a path or another URL into
+// links we generate.
+func validHost(h string) bool {
+    if h == "" || len(h) > 255 {
+        return false
+    }
+    for _, c := range h {
+        switch {
+        case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+        case strings.ContainsRune("-.:[]_", c):
+        default:
+            return false
+        }
+    }
+    return true
+}
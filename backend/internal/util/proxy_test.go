@@ -0,0 +1,166 @@
+package util
+
+import (
+    "net/http/httptest"
+    "testing"
+)
+
+func TestProxies(t *testing.T) {
+    type header struct{ name, value string }
+    tests := []struct {
+        name    string
+        family  string
+        remote  string
+        headers []header
+        ip      string
+        base    string
+    }{
+        {
+            name:   "direct client's headers are ignored",
+            family: HeaderXForwarded,
+            remote: "203.0.113.5:1234",
+            headers: []header{
+                {"X-Forwarded-For", "6.6.6.6"},
+                {"X-Forwarded-Proto", "https"},
+                {"X-Forwarded-Host", "evil.example"},
+            },
+            ip:   "203.0.113.5",
+            base: "http://talk.example",
+        },
+        {
+            name:   "one proxy",
+            family: HeaderXForwarded,
+            remote: "10.0.0.1:1234",
+            headers: []header{
+                {"X-Forwarded-For", "198.51.100.7"},
+                {"X-Forwarded-Proto", "https"},
+                {"X-Forwarded-Host", "talk.example"},
+            },
+            ip:   "198.51.100.7",
+            base: "https://talk.example",
+        },
+        {
+            name:   "client-supplied entries left of the proxy's are skipped",
+            family: HeaderXForwarded,
+            remote: "10.0.0.1:1234",
+            headers: []header{
+                {"X-Forwarded-For", "6.6.6.6, 198.51.100.7"},
+                {"X-Forwarded-Proto", "https, http"},
+                {"X-Forwarded-Host", "evil.example, talk.example"},
+            },
+            ip:   "198.51.100.7",
+            base: "http://talk.example",
+        },
+        {
+            name:   "Forwarded is ignored behind an X-Forwarded proxy",
+            family: HeaderXForwarded,
+            remote: "10.0.0.1:1234",
+            headers: []header{
+                {"Forwarded", "for=6.6.6.6;host=evil.example;proto=https"},
+                {"X-Forwarded-For", "198.51.100.7"},
+            },
+            ip:   "198.51.100.7",
+            base: "http://talk.example",
+        },
+        {
+            name:   "X-Forwarded is ignored behind a Forwarded proxy",
+            family: HeaderForwarded,
+            remote: "10.0.0.1:1234",
+            headers: []header{
+                {"X-Forwarded-For", "6.6.6.6"},
+                {"X-Forwarded-Host", "evil.example"},
+                {"Forwarded", "for=198.51.100.7;proto=https;host=talk.example"},
+            },
+            ip:   "198.51.100.7",
+            base: "https://talk.example",
+        },
+        {
+            name:   "client's Forwarded element is skipped",
+            family: HeaderForwarded,
+            remote: "10.0.0.1:1234",
+            headers: []header{
+                {"Forwarded", `for=6.6.6.6;host=evil.example;proto=https, for="198.51.100.7:4711";host=talk.example;proto=http`},
+            },
+            ip:   "198.51.100.7",
+            base: "http://talk.example",
+        },
+        {
+            name:   "two trusted proxies",
+            family: HeaderXForwarded,
+            remote: "10.0.0.2:1234",
+            headers: []header{
+                {"X-Forwarded-For", "6.6.6.6, 198.51.100.7, 10.0.0.1"},
+                {"X-Forwarded-Host", "evil.example, talk.example, inner.local"},
+                {"X-Forwarded-Proto", "http, https, http"},
+            },
+            ip:   "198.51.100.7",
+            base: "https://talk.example",
+        },
+        {
+            name:   "proxy that added no host",
+            family: HeaderXForwarded,
+            remote: "10.0.0.2:1234",
+            headers: []header{
+                {"X-Forwarded-For", "198.51.100.7, 10.0.0.1"},
+                {"X-Forwarded-Host", "evil.example"},
+            },
+            ip:   "198.51.100.7",
+            base: "http://talk.example",
+        },
+        {
+            name:   "host with a path is refused",
+            family: HeaderXForwarded,
+            remote: "10.0.0.1:1234",
+            headers: []header{
+                {"X-Forwarded-For", "198.51.100.7"},
+                {"X-Forwarded-Host", "evil.example/x"},
+            },
+            ip:   "198.51.100.7",
+            base: "http://talk.example",
+        },
+        {
+            name:   "unknown hop stops the walk",
+            family: HeaderForwarded,
+            remote: "10.0.0.1:1234",
+            headers: []header{
+                {"Forwarded", "for=6.6.6.6, for=unknown"},
+            },
+            ip:   "10.0.0.1",
+            base: "http://talk.example",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p, err := ParseProxies("10.0.0.0/24", tt.family)
+            if err != nil {
+                t.Fatal(err)
+            }
+            r := httptest.NewRequest("GET", "http://talk.example/rooms", nil)
+            r.RemoteAddr = tt.remote
+            for _, h := range tt.headers {
+                r.Header.Add(h.name, h.value)
+            }
+            if got := p.ClientIP(r); got != tt.ip {
+                t.Errorf("ClientIP = %s, want %s", got, tt.ip)
+            }
+            if got := p.BaseURL(r); got != tt.base {
+                t.Errorf("BaseURL = %s, want %s", got, tt.base)
+            }
+        })
+    }
+}
+
+func TestParseProxies(t *testing.T) {
+    if _, err := ParseProxies("10.0.0.0/8, ::1", HeaderXForwarded); err != nil {
+        t.Error(err)
+    }
+    for _, tt := range []struct{ list, family string }{
+        {"10.0.0.0/33", HeaderXForwarded},
+        {"proxy.local", HeaderXForwarded},
+        {"10.0.0.1", "x-real-ip"},
+    } {
+        if _, err := ParseProxies(tt.list, tt.family); err == nil {
+            t.Errorf("ParseProxies(%q, %q) succeeded", tt.list, tt.family)
+        }
+    }
+}
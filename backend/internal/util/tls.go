@@ -0,0 +1,122 @@
+package util
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Certificate holds a TLS key pair loaded from files and picks up new
+// versions of them, e.g. after a renewal, without a restart.
+type Certificate struct {
+    certFile, keyFile string
+
+    mu   sync.RWMutex
+    cert *tls.Certificate
+    // stamp identifies the files' versions the cert was loaded from
+    stamp string
+}
+
+// LoadCertificate loads the PEM certificate chain and key.
+func LoadCertificate(certFile, keyFile string) (*Certificate, error) {
+    c := &Certificate{certFile: certFile, keyFile: keyFile}
+    if _, err := c.reload(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// GetCertificate is for tls.Config.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.cert, nil
+}
+
+// Watch checks the files every interval until ctx ends and reloads them
+// when either changes. A pair that doesn't load (say, the certificate
+// has been replaced but not yet the key) is logged and the current one
+// kept; it is tried again at the next check.
+func (c *Certificate) Watch(ctx context.Context, interval time.Duration) {
+    t := time.NewTicker(interval)
+    defer t.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-t.C:
+        }
+        changed, err := c.reload()
+        if err != nil {
+            slog.Error("certificate reload failed", "cert", c.certFile, "err", err)
+        } else if changed {
+            slog.Info("certificate reloaded", "cert", c.certFile)
+        }
+    }
+}
+
+// reload loads the files if they differ from the loaded version.
+func (c *Certificate) reload() (bool, error) {
+    stamp, err := fileStamp(c.certFile, c.keyFile)
+    if err != nil {
+        return false, err
+    }
+    c.mu.RLock()
+    same := stamp == c.stamp
+    c.mu.RUnlock()
+    if same {
+        return false, nil
+    }
+    cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+    if err != nil {
+        return false, err
+    }
+    c.mu.Lock()
+    c.cert, c.stamp = &cert, stamp
+    c.mu.Unlock()
+    return true, nil
+}
+
+func fileStamp(paths ...string) (string, error) {
+    var s string
+    for _, p := range paths {
+        fi, err := os.Stat(p)
+        if err != nil {
+            return "", fmt.Errorf("tls: %w", err)
+        }
+        s += fi.ModTime().String() + "/" + strconv.FormatInt(fi.Size(), 10) + ";"
+    }
+    return s, nil
+}
+
+// RedirectHTTPS sends every request to the same host and path over HTTPS
+// on port. The redirect is permanent and keeps the method, so a form
+// posted to the plain-HTTP address is posted again to the HTTPS one.
+func RedirectHTTPS(port int) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        host := r.Host
+        if h, _, err := net.SplitHostPort(host); err == nil {
+            host = h
+        } else if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+            // an IPv6 literal without a port
+            host = host[1 : len(host)-1]
+        }
+        if host == "" {
+            http.Error(w, "missing host", http.StatusBadRequest)
+            return
+        }
+        if port != 443 {
+            host = net.JoinHostPort(host, strconv.Itoa(port))
+        } else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+            host = "[" + host + "]"
+        }
+        http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+    })
+}
@@ -0,0 +1,45 @@
+package util
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestRedirectHTTPS(t *testing.T) {
+    tests := []struct {
+        port int
+        host string
+        want string
+    }{
+        {443, "example.com", "https://example.com/p?q=1"},
+        {443, "example.com:80", "https://example.com/p?q=1"},
+        {8443, "example.com", "https://example.com:8443/p?q=1"},
+        {8443, "example.com:8080", "https://example.com:8443/p?q=1"},
+        {8443, "192.0.2.1:80", "https://192.0.2.1:8443/p?q=1"},
+        {443, "[::1]", "https://[::1]/p?q=1"},
+        {443, "[::1]:80", "https://[::1]/p?q=1"},
+        {8443, "[::1]", "https://[::1]:8443/p?q=1"},
+        {8443, "[2001:db8::1]:8080", "https://[2001:db8::1]:8443/p?q=1"},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodPost, "/p?q=1", nil)
+        req.Host = tt.host
+        rec := httptest.NewRecorder()
+        RedirectHTTPS(tt.port).ServeHTTP(rec, req)
+        if rec.Code != http.StatusPermanentRedirect {
+            t.Errorf("%s on %d: status %d", tt.host, tt.port, rec.Code)
+        }
+        if got := rec.Header().Get("Location"); got != tt.want {
+            t.Errorf("%s on %d: Location %q, want %q", tt.host, tt.port, got, tt.want)
+        }
+    }
+
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Host = ""
+    rec := httptest.NewRecorder()
+    RedirectHTTPS(8443).ServeHTTP(rec, req)
+    if rec.Code != http.StatusBadRequest {
+        t.Errorf("no host: status %d, want 400", rec.Code)
+    }
+}
@@ -1,32 +1,154 @@
 package main
 
 import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "flag"
     "log"
+    "log/slog"
     "net/http"
     "os"
+    "os/signal"
+    "strconv"
+    "syscall"
     "time"
 
     "slideflow/internal/app"
+    "slideflow/internal/broker"
+    "slideflow/internal/config"
+    "slideflow/internal/store"
     "slideflow/internal/util"
 )
 
+// certCheckInterval is how often the TLS certificate and key files are
+// checked for changes.
+const certCheckInterval = 30 * time.Second
+
 func main() {
-    s := app.NewServer()
+    // Settings come from defaults, then the -config file, then the
+    // environment, then flags; see internal/config.
+    cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+    if errors.Is(err, flag.ErrHelp) {
+        os.Exit(2)
+    }
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    logger, err := util.NewLogger(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+    if err != nil {
+        log.Fatal(err)
+    }
+    slog.SetDefault(logger)
 
-    port := os.Getenv("PORT")
-    if port == "" {
-        port = "8080"
+    // RedisURL keeps rooms in Redis and links replicas through it.
+    // Otherwise DataFile enables the JSON-on-disk room store, and rooms
+    // are kept in memory only when that is unset too.
+    var st store.RoomStore = store.NewMemory()
+    var b broker.Broker
+    if url := cfg.RedisURL; url != "" {
+        rs, err := store.OpenRedis(url)
+        if err != nil {
+            log.Fatal(err)
+        }
+        if b, err = broker.DialRedis(url); err != nil {
+            log.Fatal(err)
+        }
+        st = rs
+        slog.Info("room store and broker: redis")
+    } else if path := cfg.DataFile; path != "" {
+        fs, err := store.OpenFile(path)
+        if err != nil {
+            log.Fatal(err)
+        }
+        st = fs
+        slog.Info("room store: file", "path", path)
+    }
+    s, err := app.NewServer(cfg, st, b)
+    if err != nil {
+        log.Fatal(err)
     }
 
+    // Only headers are bounded here: s.Handler applies the read and write
+    // timeouts per request, sparing WebSocket and SSE streams.
     srv := &http.Server{
-        Addr:         ":" + port,
-        Handler:      util.Logging(s.Handler()),
-        ReadTimeout:  10 * time.Second,
-        WriteTimeout: 10 * time.Second,
-        IdleTimeout:  60 * time.Second,
+        Addr:              ":" + strconv.Itoa(cfg.Port),
+        Handler:           util.Logging(s.Handler()),
+        ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+        IdleTimeout:       cfg.IdleTimeout,
+    }
+    if !cfg.HTTP2 {
+        // a non-nil empty map keeps net/http from setting up HTTP/2
+        srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+    }
+    shutdownTimeout := cfg.ShutdownTimeout
+
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+
+    var redirect *http.Server
+    if cfg.TLS() {
+        cert, err := util.LoadCertificate(cfg.TLSCertFile, cfg.TLSKeyFile)
+        if err != nil {
+            log.Fatal(err)
+        }
+        go cert.Watch(ctx, certCheckInterval)
+        srv.TLSConfig = &tls.Config{
+            MinVersion:     tls.VersionTLS12,
+            GetCertificate: cert.GetCertificate,
+        }
+        if cfg.RedirectPort != 0 {
+            redirect = &http.Server{
+                Addr:              ":" + strconv.Itoa(cfg.RedirectPort),
+                Handler:           util.Logging(util.RedirectHTTPS(cfg.Port)),
+                ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+                ReadTimeout:       cfg.ReadTimeout,
+                WriteTimeout:      cfg.WriteTimeout,
+                IdleTimeout:       cfg.IdleTimeout,
+            }
+        }
     }
 
-    log.Printf("SlideFlow backend listening on :%s", port)
-    log.Fatal(srv.ListenAndServe())
+    errc := make(chan error, 2)
+    if srv.TLSConfig != nil {
+        go func() { errc <- srv.ListenAndServeTLS("", "") }()
+        slog.Info("SlideFlow backend listening", "addr", srv.Addr, "tls", true, "http2", cfg.HTTP2)
+    } else {
+        go func() { errc <- srv.ListenAndServe() }()
+        slog.Info("SlideFlow backend listening", "addr", srv.Addr)
+    }
+    if redirect != nil {
+        go func() { errc <- redirect.ListenAndServe() }()
+        slog.Info("redirecting to https", "addr", redirect.Addr)
+    }
+    select {
+    case err := <-errc:
+        log.Fatal(err)
+    case <-ctx.Done():
+    }
+    stop() // a second signal kills the process as usual
+
+    slog.Info("shutting down", "timeout", shutdownTimeout.String())
+    ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+    // Rooms first: SSE streams are in-flight requests until their hub
+    // lets them go.
+    if err := s.Shutdown(ctx); err != nil {
+        slog.Error("shutdown incomplete", "err", err)
+    }
+    if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        slog.Error("http shutdown incomplete", "err", err)
+    }
+    if redirect != nil {
+        redirect.Shutdown(ctx)
+    }
+    if err := st.Close(); err != nil {
+        slog.Error("store close failed", "err", err)
+    }
+    if b != nil {
+        b.Close()
+    }
+    slog.Info("bye")
 }
 